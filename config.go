@@ -3,6 +3,7 @@ package main
 import (
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
@@ -16,12 +17,21 @@ const (
 	// environment
 	envBasicAuthUsername string = "BASIC_AUTH_USERNAME"
 	envBasicAuthPassword string = "BASIC_AUTH_PASSWORD"
+	envCaptureSize       string = "CAPTURE_SIZE"
+	envCaptureBodySize   string = "CAPTURE_BODY_SIZE"
 	envDebug             string = "DEBUG"
+	envDiskFolder        string = "DISK_FOLDER"
+	envHooksSize         string = "HOOKS_SIZE"
+	envHooksBodySize     string = "HOOKS_BODY_SIZE"
 	envListenOn          string = "LISTEN_ON"
 	envMaxFormSize       string = "MAX_FORM_SIZE"
 	envServerTLSCert     string = "SERVER_TLS_FILE"
 	envServerTLSCertKey  string = "SERVER_TLS_KEY"
+	envShutdownTimeout   string = "SHUTDOWN_TIMEOUT"
+	envStateFile         string = "STATE_FILE"
+	envStateRestoreRAM   string = "STATE_RESTORE_RAM"
 	envStaticFolder      string = "STATIC_FOLDER"
+	envStorageFolder     string = "STORAGE_FOLDER"
 	envTempFolder        string = "TEMP_FOLDER"
 	// monitoring environment prefixes
 	envMonitoringPrefixStartup   string = "STARTUP_PROBE_"
@@ -35,7 +45,12 @@ const (
 	envMonitoringDelay       string = "DELAY"
 
 	// defaults
-	defaultListenOn string = ":8080"
+	defaultListenOn        string        = ":8080"
+	defaultCaptureSize     int           = 100
+	defaultCaptureBodySize int           = 10 * 1024 // 10KiB
+	defaultHooksSize       int           = 100
+	defaultHooksBodySize   int64         = 1024 * 1024 // 1MiB
+	defaultShutdownTimeout time.Duration = 10 * time.Second
 	//monitoring
 	defaultMonitoringStatusOk    int = http.StatusOK
 	defaultMonitoringStatusError int = http.StatusInternalServerError
@@ -44,11 +59,20 @@ const (
 type Config struct {
 	BasicAuthUsername string
 	BasicAuthPassword string
+	CaptureSize       int
+	CaptureBodySize   int
 	Debug             bool
+	DiskFolder        string
+	HooksSize         int
+	HooksBodySize     int64
 	ListenOn          string
 	TLSCert           string
 	TLSKey            string
+	ShutdownTimeout   time.Duration
+	StateFile         string
+	StateRestoreRAM   bool
 	StaticFolder      string
+	StorageFolder     string
 
 	MonitoringConfig MonitoringConfig
 }
@@ -61,6 +85,11 @@ var (
 func DefaultConfig() Config {
 	return Config{
 		ListenOn:         defaultListenOn,
+		CaptureSize:      defaultCaptureSize,
+		CaptureBodySize:  defaultCaptureBodySize,
+		HooksSize:        defaultHooksSize,
+		HooksBodySize:    defaultHooksBodySize,
+		ShutdownTimeout:  defaultShutdownTimeout,
 		MonitoringConfig: DefaultMonitoringConfig(),
 	}
 }
@@ -74,12 +103,36 @@ func (c *Config) OverwriteFromEnv() (err error) {
 	if authPassword, found := syscall.Getenv(envBasicAuthPassword); found {
 		c.BasicAuthPassword = authPassword
 	}
+	// capture size
+	if captureSizeString, found := syscall.Getenv(envCaptureSize); found {
+		if c.CaptureSize, err = strconv.Atoi(captureSizeString); err != nil {
+			return errors.WithMessagef(err, "failed to parse the %s value to int", envCaptureSize)
+		}
+	}
+	// capture body size
+	if captureBodySizeString, found := syscall.Getenv(envCaptureBodySize); found {
+		if c.CaptureBodySize, err = strconv.Atoi(captureBodySizeString); err != nil {
+			return errors.WithMessagef(err, "failed to parse the %s value to int", envCaptureBodySize)
+		}
+	}
 	// debug
 	if debugLogString, found := syscall.Getenv(envDebug); found {
 		if c.Debug, err = strconv.ParseBool(debugLogString); err != nil {
 			return errors.WithMessagef(err, "failed to parse the debug value to boolean (env variable: %s)", envDebug)
 		}
 	}
+	// hooks size
+	if hooksSizeString, found := syscall.Getenv(envHooksSize); found {
+		if c.HooksSize, err = strconv.Atoi(hooksSizeString); err != nil {
+			return errors.WithMessagef(err, "failed to parse the %s value to int", envHooksSize)
+		}
+	}
+	// hooks body size
+	if hooksBodySizeString, found := syscall.Getenv(envHooksBodySize); found {
+		if c.HooksBodySize, err = strconv.ParseInt(hooksBodySizeString, 10, 64); err != nil {
+			return errors.WithMessagef(err, "failed to parse the %s value to int", envHooksBodySize)
+		}
+	}
 	// http server listen on
 	if serverListenString, found := syscall.Getenv(envListenOn); found {
 		c.ListenOn = serverListenString
@@ -100,6 +153,22 @@ func (c *Config) OverwriteFromEnv() (err error) {
 	if tlsKey, found := syscall.Getenv(envServerTLSCertKey); found {
 		c.TLSKey = tlsKey
 	}
+	// shutdown timeout
+	if shutdownTimeoutString, found := syscall.Getenv(envShutdownTimeout); found {
+		if c.ShutdownTimeout, err = time.ParseDuration(shutdownTimeoutString); err != nil {
+			return errors.WithMessagef(err, "failed to parse the %s value to Golang duration", envShutdownTimeout)
+		}
+	}
+	// state file
+	if stateFile, found := syscall.Getenv(envStateFile); found {
+		c.StateFile = stateFile
+	}
+	// state restore RAM
+	if stateRestoreRAMString, found := syscall.Getenv(envStateRestoreRAM); found {
+		if c.StateRestoreRAM, err = strconv.ParseBool(stateRestoreRAMString); err != nil {
+			return errors.WithMessagef(err, "failed to parse the %s value to boolean", envStateRestoreRAM)
+		}
+	}
 	// static folder
 	if staticFolder, found := syscall.Getenv(envStaticFolder); found {
 		c.StaticFolder = staticFolder
@@ -108,6 +177,16 @@ func (c *Config) OverwriteFromEnv() (err error) {
 	if tempFolder, found := syscall.Getenv(envTempFolder); found {
 		TempFolderPath = tempFolder
 	}
+	// disk folder (defaults to a sub folder of the temp folder)
+	if diskFolder, found := syscall.Getenv(envDiskFolder); found {
+		c.DiskFolder = diskFolder
+	} else {
+		c.DiskFolder = filepath.Join(TempFolderPath, "disk")
+	}
+	// storage folder
+	if storageFolder, found := syscall.Getenv(envStorageFolder); found {
+		c.StorageFolder = storageFolder
+	}
 
 	// monitoring config
 	return c.MonitoringConfig.OverwriteFromEnv()
@@ -118,6 +197,20 @@ func (c Config) Validate() (err error) {
 	if (len(c.BasicAuthUsername) > 0) != (len(c.BasicAuthPassword) > 0) {
 		return errors.Errorf("both %s and %s environment variables must be set or empty", envBasicAuthUsername, envBasicAuthPassword)
 	}
+	// capture
+	if c.CaptureSize < 0 {
+		return errors.Errorf("the number of captured requests is inferior to zero (value: %d)", c.CaptureSize)
+	}
+	if c.CaptureBodySize < 0 {
+		return errors.Errorf("the captured body size is inferior to zero (value: %d)", c.CaptureBodySize)
+	}
+	// hooks
+	if c.HooksSize < 1 {
+		return errors.Errorf("the number of deliveries kept per webhook bucket is inferior to 1 (value: %d)", c.HooksSize)
+	}
+	if c.HooksBodySize < 0 {
+		return errors.Errorf("the maximum webhook delivery size is inferior to zero (value: %d)", c.HooksBodySize)
+	}
 	// static folder
 	if len(c.StaticFolder) > 0 {
 		if info, err := os.Stat(c.StaticFolder); err != nil {
@@ -134,7 +227,7 @@ func (c Config) Validate() (err error) {
 		return errors.Errorf("both %s and %s environment variables must be set or empty", envServerTLSCert, envServerTLSCertKey)
 	} else if len(c.TLSCert) > 0 {
 		for _, file := range []string{c.TLSCert, c.TLSKey} {
-			fileInfo, err := os.Stat(TempFolderPath)
+			fileInfo, err := os.Stat(file)
 			if err != nil {
 				if !errors.Is(err, os.ErrNotExist) {
 					return errors.WithMessagef(err, "failed to check if file %s exists", file)
@@ -147,6 +240,21 @@ func (c Config) Validate() (err error) {
 			}
 		}
 	}
+	// shutdown timeout
+	if c.ShutdownTimeout < 0 {
+		return errors.Errorf("the shutdown timeout is inferior to zero (value: %s)", c.ShutdownTimeout.String())
+	}
+	// state file
+	if len(c.StateFile) > 0 {
+		if info, err := os.Stat(filepath.Dir(c.StateFile)); err != nil {
+			return errors.WithMessagef(err, "failed to verify if the state file folder exists at location: %q", filepath.Dir(c.StateFile))
+		} else if !info.IsDir() {
+			return errors.Errorf("the state file folder location %q is not a directory", filepath.Dir(c.StateFile))
+		}
+		if info, err := os.Stat(c.StateFile); err == nil && info.IsDir() {
+			return errors.Errorf("the state file location %q is a directory", c.StateFile)
+		}
+	}
 	// temp folder
 	if len(TempFolderPath) == 0 {
 		return errors.New("temporary folder path is not set")
@@ -161,11 +269,40 @@ func (c Config) Validate() (err error) {
 			return errors.WithMessagef(err, "failed to create temp folder %s", TempFolderPath)
 		}
 	}
+	// disk folder
+	if err = createFolder("disk", c.DiskFolder); err != nil {
+		return
+	}
+	// storage folder
+	if len(c.StorageFolder) > 0 {
+		if err = createFolder("storage", c.StorageFolder); err != nil {
+			return
+		}
+	}
 
 	// monitoring
 	return c.MonitoringConfig.Validate()
 }
 
+// createFolder creates the folder if it doesn't exist, its path must be
+// absolute.
+func createFolder(name, folder string) error {
+	if !filepath.IsAbs(folder) {
+		return errors.Errorf("%s folder path must be absolute", name)
+	}
+	if info, err := os.Stat(folder); err == nil && !info.IsDir() {
+		return errors.Errorf("the %s folder location %q is not a directory", name, folder)
+	} else if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return errors.WithMessagef(err, "failed to check if %s folder %s exists", name, folder)
+		}
+		if err = os.MkdirAll(folder, 0750); err != nil {
+			return errors.WithMessagef(err, "failed to create %s folder %s", name, folder)
+		}
+	}
+	return nil
+}
+
 func (c Config) Log() {
 	if len(c.BasicAuthUsername) > 0 {
 		log.Debugf("CONFIG :: basic auth username: %s", c.BasicAuthUsername)
@@ -173,6 +310,14 @@ func (c Config) Log() {
 	} else {
 		log.Debug("CONFIG :: basic auth is not configured")
 	}
+	if c.CaptureSize > 0 {
+		log.Debugf("CONFIG :: number of captured requests: %d", c.CaptureSize)
+		log.Debugf("CONFIG :: captured body size: %s (%d bytes)", SizeToHumanReadable(float64(c.CaptureBodySize)), c.CaptureBodySize)
+	} else {
+		log.Debug("CONFIG :: request capture is disabled")
+	}
+	log.Debugf("CONFIG :: number of deliveries kept per webhook bucket: %d", c.HooksSize)
+	log.Debugf("CONFIG :: maximum webhook delivery size: %s (%d bytes)", SizeToHumanReadable(float64(c.HooksBodySize)), c.HooksBodySize)
 	log.Debugf("CONFIG :: maximum form size: %s (%d bytes)", SizeToHumanReadable(float64(MaxFormSize)), MaxFormSize)
 	if len(c.TLSCert) > 0 {
 		log.Debugf("CONFIG :: server TLS certificate file: %s", c.TLSCert)
@@ -180,12 +325,25 @@ func (c Config) Log() {
 	} else {
 		log.Debug("CONFIG :: server TLS is not configured")
 	}
+	log.Debugf("CONFIG :: shutdown timeout: %s", c.ShutdownTimeout.String())
+	if len(c.StateFile) > 0 {
+		log.Debugf("CONFIG :: state file: %s", c.StateFile)
+		log.Debugf("CONFIG :: restore leaked RAM: %t", c.StateRestoreRAM)
+	} else {
+		log.Debug("CONFIG :: state persistence is disabled")
+	}
 	if len(c.StaticFolder) > 0 {
 		log.Debugf("CONFIG :: static folder: %s", c.StaticFolder)
 	} else {
 		log.Debug("CONFIG :: no static folder set")
 	}
 	log.Debugf("CONFIG :: temp folder: %s", TempFolderPath)
+	log.Debugf("CONFIG :: disk folder: %s", c.DiskFolder)
+	if len(c.StorageFolder) > 0 {
+		log.Debugf("CONFIG :: storage folder: %s", c.StorageFolder)
+	} else {
+		log.Debug("CONFIG :: storage is disabled")
+	}
 	c.MonitoringConfig.Log()
 }
 
@@ -23,9 +23,16 @@ const (
 	requestFormDataConnectionTimeout string = "connection_timeout"
 	requestFormDataEchoHeaders       string = "echo_headers"
 	requestFormDataEchoBody          string = "echo_body"
+	requestFormDataEchoRedirects     string = "echo_redirects"
+	requestFormDataMaxRedirects      string = "max_redirects"
 
 	// defauts
 	requestDefaultConnectTimeout time.Duration = 20 * time.Second
+	requestDefaultMaxRedirects   int           = 10 // same as Golang HTTP clients
+
+	// number of times the same request can be made in a redirect chain before
+	// it's reported as a loop (OAuth flows legitimately come back once)
+	requestRedirectLoopVisits int = 2
 )
 
 var (
@@ -62,41 +69,48 @@ func request(l *log.Entry, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// tls config
-	transport := &http.Transport{}
-	l.Debug("generating TLS configuration (if any)")
-	transport.TLSClientConfig, err = config.tlsConfig.GetTLSConfig(l)
+	// tls & proxy config
+	transport, err := config.GetTransport(l)
 	if err != nil {
-		errorString := "invalid TLS configugration"
+		errorString := "invalid transport configuration"
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(errorString + ": " + err.Error()))
 		l.WithError(err).Warn(errorString)
 		return
 	}
 
-	// proxy config
-	l.Debug("generating proxy configuration (if any)")
-	proxyURL, err := config.GetProxyURL()
-	if err != nil {
-		errorString := "invalid proxy configugration"
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(errorString + ": " + err.Error()))
-		l.WithError(err).Warn(errorString)
-		return
-	} else if proxyURL != nil {
-		transport.Proxy = http.ProxyURL(proxyURL)
-		l.Debug("proxy configuration attached")
-	}
-
 	// making request
 	if strings.HasPrefix(config.url, "http") { // regular HTTP request
 		request := &http.Request{
 			Method: config.method,
 			URL:    u,
 		}
+		redirects := []string{}
 		cli := http.Client{
 			Transport: transport,
 			Timeout:   config.connectionTimeout,
+			CheckRedirect: func(next *http.Request, via []*http.Request) error {
+				previous := via[len(via)-1]
+				redirects = append(redirects, previous.Method+" "+previous.URL.String()+" -> "+next.Response.Status+", Location: "+next.Response.Header.Get("Location"))
+				if config.maxRedirects == 0 {
+					return http.ErrUseLastResponse
+				}
+				if len(via) >= config.maxRedirects {
+					return errors.Errorf("stopped after %d redirects", config.maxRedirects)
+				}
+				if config.echoRedirects {
+					visits := 0
+					for _, visited := range via {
+						if visited.Method == next.Method && visited.URL.String() == next.URL.String() {
+							visits++
+						}
+					}
+					if visits >= requestRedirectLoopVisits {
+						return errors.Errorf("redirect loop detected, %s %s was already requested %d times", next.Method, next.URL, visits)
+					}
+				}
+				return nil
+			},
 		}
 		l.Infof("starting HTTP request to %s", config.url)
 		answer, err := cli.Do(request)
@@ -104,11 +118,21 @@ func request(l *log.Entry, w http.ResponseWriter, r *http.Request) {
 			errorString := "failed to perform the request"
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(errorString + ": " + err.Error()))
+			if config.echoRedirects {
+				w.Write([]byte("\n--- REDIRECTS\n" + strings.Join(redirects, "\n") + "\n"))
+			}
 			l.WithError(err).Warn(errorString)
 			return
 		}
 
 		w.WriteHeader(http.StatusOK)
+		// echo redirects
+		if config.echoRedirects {
+			if config.maxRedirects > 0 || len(redirects) == 0 { // the last redirect is the answer if redirects aren't followed
+				redirects = append(redirects, answer.Request.Method+" "+answer.Request.URL.String()+" -> "+answer.Status)
+			}
+			w.Write([]byte("--- REDIRECTS\n" + strings.Join(redirects, "\n") + "\n\n"))
+		}
 		// echo headers
 		if config.echoHeaders {
 			w.Write([]byte("--- ANSWER HEADERS\n"))
@@ -160,6 +184,8 @@ type requestConfig struct {
 	connectionTimeout time.Duration
 	echoHeaders       bool
 	echoBody          bool
+	echoRedirects     bool
+	maxRedirects      int
 }
 
 func parseRequestConfigFromFormData(l *log.Entry, r *http.Request) (c requestConfig, err error) {
@@ -201,6 +227,22 @@ func parseRequestConfigFromFormData(l *log.Entry, r *http.Request) (c requestCon
 			return c, errors.WithMessage(err, "failed to parse echo body to boolean")
 		}
 	}
+	// echo redirects
+	echoRedirectsString := strings.TrimSpace(r.FormValue(requestFormDataEchoRedirects))
+	if len(echoRedirectsString) > 0 {
+		if c.echoRedirects, err = strconv.ParseBool(echoRedirectsString); err != nil {
+			return c, errors.WithMessage(err, "failed to parse echo redirects to boolean")
+		}
+	}
+	// max redirects
+	c.maxRedirects = requestDefaultMaxRedirects
+	maxRedirectsString := strings.TrimSpace(r.FormValue(requestFormDataMaxRedirects))
+	if len(maxRedirectsString) > 0 {
+		if !positiveIntegerRegex.MatchString(maxRedirectsString) {
+			return c, errors.Errorf("max redirects doesn't match regex: %s (value: %s)", positiveIntegerRegex.String(), maxRedirectsString)
+		}
+		c.maxRedirects, _ = strconv.Atoi(maxRedirectsString) // can't fail thanks to the regexp
+	}
 	// tls
 	r.Form.Add(tlsFormDataTLSEnabled, strconv.FormatBool(c.tlsEnabled())) // needed to be manually added since the option does not exist in this endpoint (determined from scheme)
 	c.tlsConfig, err = ParseTLSConfigFromFormData(l, r)
@@ -235,6 +277,31 @@ func (c requestConfig) Validate() error {
 	return c.tlsConfig.Validate()
 }
 
+// GetTransport returns the HTTP transport configured with the TLS and proxy
+// configurations. An error is returned if one of them is invalid.
+func (c requestConfig) GetTransport(l *log.Entry) (*http.Transport, error) {
+	// tls config
+	var err error
+	transport := &http.Transport{}
+	l.Debug("generating TLS configuration (if any)")
+	transport.TLSClientConfig, err = c.tlsConfig.GetTLSConfig(l)
+	if err != nil {
+		return nil, errors.WithMessage(err, "invalid TLS configuration")
+	}
+
+	// proxy config
+	l.Debug("generating proxy configuration (if any)")
+	proxyURL, err := c.GetProxyURL()
+	if err != nil {
+		return nil, errors.WithMessage(err, "invalid proxy configuration")
+	} else if proxyURL != nil {
+		transport.Proxy = http.ProxyURL(proxyURL)
+		l.Debug("proxy configuration attached")
+	}
+
+	return transport, nil
+}
+
 func (c requestConfig) GetProxyURL() (*url.URL, error) {
 	if len(c.proxyURL) == 0 {
 		return nil, nil
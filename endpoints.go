@@ -1,123 +1,202 @@
 package main
 
 import (
+	"bytes"
+	"encoding/base64"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
-	"syscall"
+	"strings"
 	"time"
 
+	"github.com/pkg/errors"
 	probing "github.com/prometheus-community/pro-bing"
 	log "github.com/sirupsen/logrus"
 )
 
 const (
 	// query params
-	queryParamCode     string = "code"
-	queryParamCount    string = "count"
-	queryParamDuration string = "duration"
-	queryParamHeaders  string = "headers"
-	queryParamHost     string = "host"
-	queryParamSize     string = "size"
-	queryParamTimeout  string = "timeout"
-
-	size1MiB           int           = 1024 * 1024 // 1MiB
-	defaultPingTimeout time.Duration = 20 * time.Second
+	queryParamBody            string = "body"
+	queryParamBodyBase64      string = "body_base64"
+	queryParamChunkSize       string = "chunk_size"
+	queryParamCode            string = "code"
+	queryParamContent         string = "content"
+	queryParamContentType     string = "content_type"
+	queryParamCount           string = "count"
+	queryParamDelay           string = "delay"
+	queryParamDuration        string = "duration"
+	queryParamFile            string = "file"
+	queryParamFilename        string = "filename"
+	queryParamFlush           string = "flush"
+	queryParamFormat          string = "format"
+	queryParamHeader          string = "header"
+	queryParamHeaders         string = "headers"
+	queryParamHost            string = "host"
+	queryParamJitter          string = "jitter"
+	queryParamLocation        string = "location"
+	queryParamRate            string = "rate"
+	queryParamRepeat          string = "repeat"
+	queryParamRetryAfter      string = "retry_after"
+	queryParamSeed            string = "seed"
+	queryParamSetCookie       string = "set_cookie"
+	queryParamSize            string = "size"
+	queryParamText            string = "text"
+	queryParamTimeout         string = "timeout"
+	queryParamWWWAuthenticate string = "www_authenticate"
+
+	// answer formats
+	echoFormatText string = "text"
+	echoFormatJSON string = "json"
+
+	size1MiB            int           = 1024 * 1024 // 1MiB
+	statusCodeMaxWeight int           = 1_000_000
+	echoRawMaxRepeat    int           = 1000
+	defaultPingTimeout  time.Duration = 20 * time.Second
 )
 
 var (
 	positiveIntegerRegex *regexp.Regexp = regexp.MustCompile("^[0-9]+$")
 	statusCodeRegex      *regexp.Regexp = regexp.MustCompile("^[1-5][0-9]{2}$")
+	// informational status codes (1xx) are followed by an implicit 200 answer
+	finalStatusCodeRegex *regexp.Regexp = regexp.MustCompile("^[2-5][0-9]{2}$")
+	statusClassRegex     *regexp.Regexp = regexp.MustCompile("^[2-5][xX]{2}$")
 )
 
-/* CRASH */
-func crash(l *log.Entry, w http.ResponseWriter, r *http.Request) {
-	// default exit code
-	exitCode := 1
-
-	// parsing exit code
-	var err error
-	exitCodeString := r.URL.Query().Get(queryParamCode)
-	if len(exitCodeString) > 0 {
-		if exitCode, err = strconv.Atoi(exitCodeString); err != nil {
-			errorString := fmt.Sprintf("query param %s is not an integer", queryParamCode)
+/* DRIP */
+func drip(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	l.Debug("parsing query variables")
+	query := r.URL.Query()
+	// size
+	size := int64(1024)
+	if sizeString := query.Get(queryParamSize); len(sizeString) > 0 {
+		var err error
+		if size, err = parseSize(sizeString); err != nil {
+			errorString := err.Error()
 			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(errorString + ": " + err.Error()))
-			l.WithError(err).Warn(errorString)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+	}
+	// chunk size
+	chunkSize := int64(1)
+	if chunkSizeString := query.Get(queryParamChunkSize); len(chunkSizeString) > 0 {
+		if !positiveIntegerRegex.MatchString(chunkSizeString) {
+			errorString := "chunk size doesn't match regex: " + positiveIntegerRegex.String()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
 			return
-		} else if exitCode < 0 {
-			errorString := fmt.Sprintf("query param %s is inferior to 0 (value: %d)", queryParamCode, exitCode)
+		}
+		var err error
+		if chunkSize, err = strconv.ParseInt(chunkSizeString, 10, 64); err != nil || chunkSize < 1 || chunkSize > int64(size1MiB) {
+			errorString := fmt.Sprintf("chunk size must be between 1 and %d", size1MiB)
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(errorString))
 			l.Warn(errorString)
 			return
 		}
 	}
-	// parsing timeout
-	timeout := time.Second
-	timeoutString := r.URL.Query().Get(queryParamTimeout)
-	if len(timeoutString) > 0 {
-		timeout, err = time.ParseDuration(timeoutString)
-		if err != nil {
-			errorString := "timeout is incorrect: " + err.Error()
+	// duration
+	duration := 10 * time.Second
+	if durationString := query.Get(queryParamDuration); len(durationString) > 0 {
+		var err error
+		if duration, err = time.ParseDuration(durationString); err != nil {
+			errorString := "duration is incorrect: " + err.Error()
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(errorString))
 			l.Warn(errorString)
 			return
-		} else if timeout < 0 {
-			errorString := "timeout is inferior to zero: " + timeout.String()
+		} else if duration < 0 {
+			errorString := "duration is inferior to zero: " + duration.String()
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(errorString))
 			l.Warn(errorString)
 			return
 		}
 	}
-	w.WriteHeader(http.StatusOK)
-	l.Infof("server will crash in %s with exit code: %d", timeout.String(), exitCode)
-
-	// crash
-	go func(exitCode int) {
-		time.Sleep(timeout)
-		syscall.Exit(exitCode)
-	}(exitCode)
-}
-
-/* DOWNLOAD */
-func download(l *log.Entry, w http.ResponseWriter, r *http.Request) {
-	// parsing size
-	size := size1MiB
-	sizeString := r.URL.Query().Get(queryParamSize)
-	if len(sizeString) > 0 {
-		if !positiveIntegerRegex.MatchString(sizeString) {
-			errorString := "size doesn't match regex: " + positiveIntegerRegex.String()
+	// delay
+	var delay time.Duration
+	if delayString := query.Get(queryParamDelay); len(delayString) > 0 {
+		var err error
+		if delay, err = time.ParseDuration(delayString); err != nil {
+			errorString := "delay is incorrect: " + err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		} else if delay < 0 {
+			errorString := "delay is inferior to zero: " + delay.String()
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(errorString))
 			l.Warn(errorString)
 			return
 		}
-		size, _ = strconv.Atoi(sizeString) // can't fail thanks to the regexp
 	}
-
-	l.Infof("starting download of %s (%d Bytes)", SizeToHumanReadable(float64(size)), size)
-	defer l.Info("download finished")
-	w.Header().Add("Content-Length", sizeString)
-	w.WriteHeader(http.StatusOK)
-
-	// populating download data
-	downloadData := make([]byte, 10*1024*1024) // 10KB
-	for i := range downloadData {
-		downloadData[i] = 0x00
+	// flush
+	flush := true
+	if flushString := query.Get(queryParamFlush); len(flushString) > 0 {
+		var err error
+		if flush, err = strconv.ParseBool(flushString); err != nil {
+			errorString := "flush is incorrect: " + err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+	}
+	// status code
+	status := http.StatusOK
+	if statusCodeString := query.Get(queryParamCode); len(statusCodeString) > 0 {
+		if !statusCodeRegex.MatchString(statusCodeString) {
+			errorString := "status code doesn't match regex: " + statusCodeRegex.String()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+		status, _ = strconv.Atoi(statusCodeString) // can't fail thanks to the regexp
 	}
 
-	// sending data
-	dataSize := len(downloadData)
-	for size > dataSize {
-		w.Write(downloadData)
-		size = size - dataSize
+	// initial delay, before the answer headers
+	if err := sleepContext(r.Context(), delay); err != nil {
+		l.WithError(err).Warn("drip canceled during the initial delay")
+		return
+	}
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if !bodyAllowedForStatus(status) {
+		l.Infof("no body dripped with the status code %d", status)
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.WriteHeader(status)
+	controller.Flush()
+
+	// dripping chunks evenly over the duration, the last one at the end
+	l.Infof("dripping %d Bytes in chunks of %d Bytes over %s (flush: %t)", size, chunkSize, duration, flush)
+	startDate := time.Now()
+	nbChunks := (size + chunkSize - 1) / chunkSize
+	chunk := bytes.Repeat([]byte("*"), int(min(chunkSize, size)))
+	for i := int64(1); i <= nbChunks; i++ {
+		if err := sleepContext(r.Context(), time.Until(startDate.Add(time.Duration(float64(duration)*float64(i)/float64(nbChunks))))); err != nil {
+			l.WithError(err).Warnf("drip canceled after %d chunk(s)", i-1)
+			return
+		}
+		if _, err := w.Write(chunk[:min(chunkSize, size-(i-1)*chunkSize)]); err != nil {
+			l.WithError(err).Warnf("failed to write chunk %d", i)
+			return
+		}
+		if flush {
+			controller.Flush()
+		}
 	}
-	w.Write(downloadData[:size])
+	l.Infof("%d Bytes dripped in %d chunk(s) in %s", size, nbChunks, time.Since(startDate).String())
 }
 
 /* ECHO */
@@ -136,6 +215,34 @@ func echo(l *log.Entry, w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	// format query
+	format, ok := parseFormat(l, w, r.URL.Query())
+	if !ok {
+		return
+	}
+
+	// structured answer
+	if format == echoFormatJSON {
+		r.Body = http.MaxBytesReader(w, r.Body, MaxFormSize)
+		echoRequest, err := NewEchoRequest(l, r)
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				errorString := fmt.Sprintf("request body is bigger than the maximum form size of %s (%d Bytes)", SizeToHumanReadable(float64(MaxFormSize)), MaxFormSize)
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				w.Write([]byte(errorString))
+				l.Warn(errorString)
+				return
+			}
+			errorString := "failed to read request body"
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString + ": " + err.Error()))
+			l.WithError(err).Warn(errorString)
+			return
+		}
+		writeJSON(l, w, echoRequest)
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 
@@ -172,12 +279,41 @@ func echoForm(l *log.Entry, w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// file query
+	fileField := r.URL.Query().Get(queryParamFile)
+
 	// parse form
+	r.Body = http.MaxBytesReader(w, r.Body, MaxFormSize)
 	if err = r.ParseMultipartForm(MaxFormSize); err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			errorString := fmt.Sprintf("form is bigger than the maximum form size of %s (%d Bytes)", SizeToHumanReadable(float64(MaxFormSize)), MaxFormSize)
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
 		errorString := "failed to parse form"
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(errorString + ": " + err.Error()))
-		l.WithError(err).Warnf(errorString)
+		l.WithError(err).Warn(errorString)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	// file content as answer
+	if len(fileField) > 0 {
+		echoFormFile(l, w, r, fileField)
+		return
+	}
+
+	// files details
+	files, err := NewFormFiles(r.MultipartForm)
+	if err != nil {
+		errorString := "failed to read uploaded files"
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Error(errorString)
 		return
 	}
 
@@ -199,6 +335,57 @@ func echoForm(l *log.Entry, w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}
+
+	// files
+	if len(files) > 0 {
+		w.Write([]byte("\n--- FILES\n"))
+		for _, file := range files {
+			w.Write([]byte(file.String() + "\n"))
+		}
+	}
+}
+
+// echoFormFile answers with the content of the first file uploaded with the
+// given field name.
+func echoFormFile(l *log.Entry, w http.ResponseWriter, r *http.Request, field string) {
+	fileHeaders := r.MultipartForm.File[field]
+	if len(fileHeaders) == 0 {
+		errorString := fmt.Sprintf("no file uploaded with field name %q", field)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return
+	}
+	file, err := NewFormFile(field, fileHeaders[0])
+	if err != nil {
+		errorString := "failed to read uploaded file"
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Error(errorString)
+		return
+	}
+	content, err := fileHeaders[0].Open()
+	if err != nil {
+		errorString := "failed to open uploaded file"
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Error(errorString)
+		return
+	}
+	defer content.Close()
+
+	contentType := file.ContentType
+	if len(contentType) == 0 {
+		contentType = file.SniffedContentType
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(file.Size, 10))
+	w.WriteHeader(http.StatusOK)
+	if _, err = io.Copy(w, content); err != nil {
+		l.WithError(err).Error("failed to copy uploaded file to answer")
+		return
+	}
+	l.Infof("answered with uploaded file: %s", file.String())
 }
 
 func echoRaw(l *log.Entry, w http.ResponseWriter, r *http.Request) {
@@ -216,6 +403,15 @@ func echoRaw(l *log.Entry, w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	// response shaping
+	response, err := parseEchoRawResponse(r.URL.Query())
+	if err != nil {
+		errorString := "failed to parse response query vars"
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Warn(errorString)
+		return
+	}
 
 	// write headers
 	if echoHeaders {
@@ -226,11 +422,233 @@ func echoRaw(l *log.Entry, w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}
+	for name, values := range response.headers {
+		w.Header()[name] = values
+	}
+	if len(response.contentType) > 0 {
+		w.Header().Set("Content-Type", response.contentType)
+	}
+	for _, cookie := range response.cookies {
+		w.Header().Add("Set-Cookie", cookie)
+	}
+
+	// no body
+	if !bodyAllowedForStatus(response.status) {
+		l.Infof("no body echoed with the status code %d", response.status)
+		w.WriteHeader(response.status)
+		return
+	}
+
+	// body from query
+	if response.body != nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(int64(len(response.body))*int64(response.repeat), 10))
+		w.WriteHeader(response.status)
+		for range response.repeat {
+			w.Write(response.body)
+		}
+		return
+	}
 
+	// request body
+	if response.repeat > 1 {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxFormSize))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				errorString := fmt.Sprintf("request body is bigger than the maximum form size of %s (%d Bytes) to be repeated", SizeToHumanReadable(float64(MaxFormSize)), MaxFormSize)
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				w.Write([]byte(errorString))
+				l.Warn(errorString)
+				return
+			}
+			errorString := "failed to read request body"
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString + ": " + err.Error()))
+			l.WithError(err).Warn(errorString)
+			return
+		}
+		w.WriteHeader(response.status)
+		for range response.repeat {
+			w.Write(body)
+		}
+		return
+	}
+	w.WriteHeader(response.status)
+	writeBody(l, w, r.Body)
+}
+
+func echoStream(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	l.Debug("parsing query variables")
+	// chunk size
+	chunkSize := 1024
+	chunkSizeString := r.URL.Query().Get(queryParamChunkSize)
+	if len(chunkSizeString) > 0 {
+		if !positiveIntegerRegex.MatchString(chunkSizeString) {
+			errorString := "chunk size doesn't match regex: " + positiveIntegerRegex.String()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+		var err error
+		if chunkSize, err = strconv.Atoi(chunkSizeString); err != nil || chunkSize < 1 || chunkSize > size1MiB {
+			errorString := fmt.Sprintf("chunk size must be between 1 and %d", size1MiB)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+	}
+	// delay
+	var delay time.Duration
+	var err error
+	delayString := r.URL.Query().Get(queryParamDelay)
+	if len(delayString) > 0 {
+		if delay, err = time.ParseDuration(delayString); err != nil {
+			errorString := "delay is incorrect: " + err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		} else if delay < 0 {
+			errorString := "delay is inferior to zero: " + delay.String()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+	}
+
+	// full duplex (HTTP/2 is always full duplex)
+	controller := http.NewResponseController(w)
+	if err = controller.EnableFullDuplex(); err != nil {
+		l.WithError(err).Debug("full duplex not supported")
+	}
+
+	// empty read, to send the "100 Continue" answer before the answer headers,
+	// otherwise the request body gets closed
+	if strings.EqualFold(r.Header.Get("Expect"), "100-continue") {
+		r.Body.Read(nil)
+	}
+
+	// answer headers
+	contentType := r.Header.Get("Content-Type")
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(http.StatusOK)
+	if err = controller.Flush(); err != nil {
+		l.WithError(err).Warn("failed to flush answer headers, the answer will be buffered")
+	}
+
+	// echoing chunks as soon as they are received
+	startDate := time.Now()
+	var size, nbChunks int
+	chunk := make([]byte, chunkSize)
+	for {
+		n, err := r.Body.Read(chunk)
+		if n > 0 {
+			if sleepErr := sleepContext(r.Context(), delay); sleepErr != nil {
+				l.WithError(sleepErr).Warnf("stream canceled after %d chunk(s)", nbChunks)
+				return
+			}
+			if _, writeErr := w.Write(chunk[:n]); writeErr != nil {
+				l.WithError(writeErr).Warn("failed to write chunk")
+				return
+			}
+			controller.Flush()
+			size += n
+			nbChunks++
+			l.Debugf("chunk %d of %d Bytes echoed after %s", nbChunks, n, time.Since(startDate).String())
+		}
+		if err != nil {
+			if err != io.EOF {
+				l.WithError(err).Warn("failed to read request body")
+			}
+			break
+		}
+	}
+	l.Infof("%d Bytes echoed in %d chunk(s) in %s", size, nbChunks, time.Since(startDate).String())
+}
 
+type echoRawResponse struct {
+	status      int
+	headers     http.Header
+	contentType string
+	cookies     []string
+	body        []byte // nil to echo the request body
+	repeat      int
+}
+
+func parseEchoRawResponse(query url.Values) (response echoRawResponse, err error) {
+	response = echoRawResponse{
+		status:      http.StatusOK,
+		headers:     http.Header{},
+		contentType: query.Get(queryParamContentType),
+		cookies:     query[queryParamSetCookie],
+		repeat:      1,
+	}
+	// status
+	if statusString := query.Get(queryParamCode); len(statusString) > 0 {
+		if !finalStatusCodeRegex.MatchString(statusString) {
+			return response, errors.Errorf("status code doesn't match regex: %s (value: %s)", finalStatusCodeRegex.String(), statusString)
+		}
+		response.status, _ = strconv.Atoi(statusString) // can't fail thanks to the regexp
+	}
+	// headers
+	for _, header := range query[queryParamHeader] {
+		name, value, found := strings.Cut(header, ":")
+		if !found || len(strings.TrimSpace(name)) == 0 {
+			return response, errors.Errorf("header %q must be formatted as 'Name: value'", header)
+		}
+		response.headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
 	// body
-	writeBody(l, w, r.Body)
+	if query.Has(queryParamBody) && query.Has(queryParamBodyBase64) {
+		return response, errors.Errorf("only one of %s and %s can be set", queryParamBody, queryParamBodyBase64)
+	} else if query.Has(queryParamBody) {
+		response.body = []byte(query.Get(queryParamBody))
+	} else if query.Has(queryParamBodyBase64) {
+		if response.body, err = base64.StdEncoding.DecodeString(query.Get(queryParamBodyBase64)); err != nil {
+			return response, errors.WithMessagef(err, "failed to decode %s", queryParamBodyBase64)
+		}
+	}
+	// repeat
+	if repeatString := query.Get(queryParamRepeat); len(repeatString) > 0 {
+		if !positiveIntegerRegex.MatchString(repeatString) {
+			return response, errors.Errorf("repeat doesn't match regex: %s (value: %s)", positiveIntegerRegex.String(), repeatString)
+		}
+		if response.repeat, err = strconv.Atoi(repeatString); err != nil || response.repeat > echoRawMaxRepeat {
+			return response, errors.Errorf("repeat is superior to %d (value: %s)", echoRawMaxRepeat, repeatString)
+		} else if response.repeat == 0 {
+			return response, errors.New("repeat must be superior to zero")
+		}
+	}
+	return
+}
+
+// bodyAllowedForStatus tells whether an answer with the status code may have
+// a body (not for 1xx, 204 and 304, see RFC 9110).
+func bodyAllowedForStatus(status int) bool {
+	return status >= http.StatusOK && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// parseFormat parses the format query parameter. If it is incorrect,
+// the error is written to the client and ok is false.
+func parseFormat(l *log.Entry, w http.ResponseWriter, query url.Values) (format string, ok bool) {
+	switch format = query.Get(queryParamFormat); format {
+	case "":
+		return echoFormatText, true
+	case echoFormatText, echoFormatJSON:
+		return format, true
+	default:
+		errorString := fmt.Sprintf("unknown format: %q, must be one of: %s, %s", format, echoFormatText, echoFormatJSON)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return "", false
+	}
 }
 
 func writeRequestHeaders(w http.ResponseWriter, r *http.Request) {
@@ -351,19 +769,14 @@ func ping(l *log.Entry, w http.ResponseWriter, r *http.Request) {
 /* SLEEP */
 func sleep(l *log.Entry, w http.ResponseWriter, r *http.Request) {
 	l.Debug("parsing query variables")
-	// parsing duration
-	duration := time.Second
-	var err error
-	sleepDurationString := r.URL.Query().Get(queryParamDuration)
-	if len(sleepDurationString) > 0 {
-		duration, err = time.ParseDuration(sleepDurationString)
-		if err != nil {
-			errorString := "sleep duration is incorrect"
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(errorString + ": " + err.Error()))
-			l.WithError(err).Warn(errorString)
-			return
-		}
+	// parsing duration, or distribution
+	distribution, err := ParseLatencyDistribution(r.URL.Query(), time.Second)
+	if err != nil {
+		errorString := "sleep duration is incorrect"
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Warn(errorString)
+		return
 	}
 	// parsing status code
 	status := http.StatusOK
@@ -380,55 +793,125 @@ func sleep(l *log.Entry, w http.ResponseWriter, r *http.Request) {
 	}
 
 	// sleep
-	l.Infof("endpoint is going to sleep for: %s", duration.String())
-	time.Sleep(duration)
+	duration := distribution.Sample()
+	l.Infof("endpoint is going to sleep for: %s (distribution: %s)", duration.String(), distribution)
+	if err = sleepContext(r.Context(), duration); err != nil {
+		l.WithError(err).Warn("client left before the end of the sleep")
+		return
+	}
 
-	// sending back status code
+	// sending back status code, and the sampled duration (in milliseconds,
+	// since the microseconds unit of durations is not ASCII)
+	w.Header().Set("X-Sleep-Duration", strconv.FormatFloat(float64(duration)/float64(time.Millisecond), 'f', 3, 64)+"ms")
 	w.WriteHeader(status)
 }
 
 /* STATUS */
 func statusCode(l *log.Entry, w http.ResponseWriter, r *http.Request) {
 	l.Debug("parsing query variables")
-	// parsing status code
-	statusCodeString := r.URL.Query().Get(queryParamCode)
-	if !statusCodeRegex.MatchString(statusCodeString) {
-		errorString := "status code doesn't match regex: " + positiveIntegerRegex.String()
+	query := r.URL.Query()
+	// parsing status codes
+	statusCodes, err := parseWeightedStatusCodes(query.Get(queryParamCode))
+	if err != nil {
+		errorString := err.Error()
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(errorString))
 		l.Warn(errorString)
 		return
 	}
 
+	// picking the status code, and its headers
+	status := statusCodes.pick()
+	var header, value string
+	switch {
+	case status >= 300 && status < 400:
+		header, value = "Location", query.Get(queryParamLocation)
+	case status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable:
+		header, value = "Retry-After", query.Get(queryParamRetryAfter)
+	case status == http.StatusUnauthorized:
+		header, value = "WWW-Authenticate", query.Get(queryParamWWWAuthenticate)
+	}
+	if len(value) > 0 {
+		w.Header().Set(header, value)
+	}
+
 	// sending back status code
-	status, _ := strconv.Atoi(statusCodeString) // can't fail thanks to the regexp
+	if len(statusCodes) > 1 || statusCodes[0].class > 0 {
+		l.Infof("answering with status code %d, picked from: %s", status, query.Get(queryParamCode))
+	}
 	w.WriteHeader(status)
 }
 
-/* UPLOAD */
-func upload(l *log.Entry, w http.ResponseWriter, r *http.Request) {
-	l.Info("start uploading")
-
-	// buffers
-	tmp := make([]byte, 1024) // 1KB temp buffer
-	size := 0                 // size of the body request
-	for {
-		// partial read
-		n, err := r.Body.Read(tmp)
-		size += n
+// weightedStatusCode is a status code (or a class of status codes) and its
+// weight, among the status codes the server can answer with.
+type weightedStatusCode struct {
+	code   int
+	class  int // first digit of the class (i.e.: 5 for 5xx), 0 for an exact code
+	weight int
+}
 
-		// EOF or error
-		if err != nil {
-			if err != io.EOF {
-				l.WithError(err).Error("failed to read request body")
-				return
+type weightedStatusCodes []weightedStatusCode
+
+// parseWeightedStatusCodes parses a comma separated list of status codes (or
+// classes, such as 5xx) with their optional weight: 200:90,503:8,5xx:2.
+func parseWeightedStatusCodes(codesString string) (codes weightedStatusCodes, err error) {
+	for _, codeString := range strings.Split(codesString, ",") {
+		code := weightedStatusCode{weight: 1}
+		codeString, weightString, hasWeight := strings.Cut(strings.TrimSpace(codeString), ":")
+		if hasWeight {
+			if !positiveIntegerRegex.MatchString(weightString) {
+				return nil, errors.Errorf("weight doesn't match regex: %s (value: %s)", positiveIntegerRegex.String(), weightString)
+			}
+			if code.weight, err = strconv.Atoi(weightString); err != nil || code.weight > statusCodeMaxWeight {
+				return nil, errors.Errorf("weight is superior to %d (value: %s)", statusCodeMaxWeight, weightString)
 			}
-			break
 		}
+		if statusClassRegex.MatchString(codeString) {
+			code.class = int(codeString[0] - '0')
+		} else if finalStatusCodeRegex.MatchString(codeString) {
+			code.code, _ = strconv.Atoi(codeString) // can't fail thanks to the regexp
+		} else {
+			return nil, errors.Errorf("status code doesn't match regex: %s or %s (value: %s)", finalStatusCodeRegex.String(), statusClassRegex.String(), codeString)
+		}
+		codes = append(codes, code)
+	}
+	if codes.total() <= 0 {
+		return nil, errors.New("the sum of the weights must be superior to zero")
 	}
+	return codes, nil
+}
 
-	answerText := fmt.Sprintf("upload done, %s sent (%d Bytes)", SizeToHumanReadable(float64(size)), size)
-	l.Info(answerText)
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(answerText))
+// total returns the sum of the weights, it can't overflow since the weights
+// and the number of status codes (limited by the URL size) are bounded.
+func (codes weightedStatusCodes) total() (total int) {
+	for _, code := range codes {
+		total += code.weight
+	}
+	return total
+}
+
+// pick returns a random status code, according to the weights. A class is
+// replaced by one of its known status codes.
+func (codes weightedStatusCodes) pick() int {
+	total := codes.total()
+	if total <= 0 { // can't happen, checked when parsing
+		return http.StatusInternalServerError
+	}
+	n := rand.IntN(total)
+	for _, code := range codes {
+		if n -= code.weight; n >= 0 {
+			continue
+		}
+		if code.class == 0 {
+			return code.code
+		}
+		known := []int{}
+		for status := code.class * 100; status < (code.class+1)*100; status++ {
+			if len(http.StatusText(status)) > 0 {
+				known = append(known, status)
+			}
+		}
+		return known[rand.IntN(len(known))]
+	}
+	return codes[len(codes)-1].code // can't happen
 }
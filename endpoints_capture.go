@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// query params
+	captureQueryParamID     string = "id"
+	captureQueryParamPath   string = "path"
+	captureQueryParamMethod string = "method"
+	captureQueryParamHeader string = "header"
+	captureQueryParamSince  string = "since"
+	captureQueryParamUntil  string = "until"
+	captureQueryParamLimit  string = "limit"
+	captureQueryParamFormat string = "format"
+
+	// formats
+	captureFormatText string = "text"
+	captureFormatJSON string = "json"
+	captureFormatHAR  string = "har"
+)
+
+// CapturedRequest is a request recorded by the capture middleware.
+type CapturedRequest struct {
+	ID              int64         `json:"id"`
+	Date            time.Time     `json:"date"`
+	Duration        time.Duration `json:"duration_ns"`
+	Method          string        `json:"method"`
+	URL             string        `json:"url"`
+	Path            string        `json:"path"`
+	Proto           string        `json:"proto"`
+	Host            string        `json:"host"`
+	RemoteAddr      string        `json:"remote_addr"`
+	Headers         http.Header   `json:"headers"`
+	Body            string        `json:"body"`
+	BodySize        int64         `json:"body_size"`
+	BodyTruncated   bool          `json:"body_truncated"`
+	Status          int           `json:"status"`
+	ResponseHeaders http.Header   `json:"response_headers"`
+	ResponseLength  int64         `json:"response_length"`
+}
+
+func (c CapturedRequest) String() string {
+	return fmt.Sprintf("#%d %s client:%s request:\"%s %s %s\" status_code:%d length:%d body_size:%d timing_ns:%d", c.ID, c.Date.Format(time.RFC3339), c.RemoteAddr, c.Method, c.URL, c.Proto, c.Status, c.ResponseLength, c.BodySize, c.Duration.Nanoseconds())
+}
+
+// HAR converts the captured request to an HTTP archive entry.
+func (c CapturedRequest) HAR() HAREntry {
+	// request
+	request := HARRequest{
+		Method:      c.Method,
+		URL:         c.URL,
+		HTTPVersion: c.Proto,
+		Cookies:     harCookies((&http.Request{Header: c.Headers}).Cookies()),
+		Headers:     harHeaders(c.Headers),
+		QueryString: harQueryString(""),
+		HeadersSize: -1,
+		BodySize:    c.BodySize,
+	}
+	if i := strings.Index(c.URL, "?"); i >= 0 {
+		request.QueryString = harQueryString(c.URL[i+1:])
+	}
+	if c.BodySize > 0 {
+		request.PostData = &HARPostData{
+			MimeType: c.Headers.Get("Content-Type"),
+			Text:     c.Body,
+		}
+		if c.BodyTruncated {
+			request.PostData.Comment = fmt.Sprintf("body truncated to %d Bytes", len(c.Body))
+		}
+	}
+
+	// response
+	response := HARResponse{
+		Status:      c.Status,
+		StatusText:  http.StatusText(c.Status),
+		HTTPVersion: c.Proto,
+		Cookies:     harCookies((&http.Response{Header: c.ResponseHeaders}).Cookies()),
+		Headers:     harHeaders(c.ResponseHeaders),
+		Content: HARContent{
+			Size:     c.ResponseLength,
+			MimeType: c.ResponseHeaders.Get("Content-Type"),
+		},
+		RedirectURL: c.ResponseHeaders.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    c.ResponseLength,
+	}
+
+	return HAREntry{
+		StartedDateTime: c.Date.Format(time.RFC3339Nano),
+		Time:            harDuration(c.Duration),
+		Request:         request,
+		Response:        response,
+		Timings: HARTimings{
+			Wait: harDuration(c.Duration),
+		},
+		Comment: fmt.Sprintf("captured request #%d from %s", c.ID, c.RemoteAddr),
+	}
+}
+
+// CaptureEndpoints records incoming requests in a bounded in-memory ring
+// buffer, and exposes them.
+type CaptureEndpoints struct {
+	lock        *sync.Mutex
+	requests    []CapturedRequest // ring buffer
+	size        int
+	next        int // next index to write in the ring buffer, once full
+	lastID      int64
+	maxBodySize int
+}
+
+func NewCaptureEndpoints(size, maxBodySize int) *CaptureEndpoints {
+	return &CaptureEndpoints{
+		lock:        &sync.Mutex{},
+		requests:    make([]CapturedRequest, 0, size),
+		size:        size,
+		maxBodySize: maxBodySize,
+	}
+}
+
+// MiddleWare records requests once processed. If the response writer is not a
+// ResponseWriterInspector (from the LogRequestMiddleWare), it gets wrapped.
+// The request body is recorded up to the maximum body size, even if the
+// endpoint did not read it.
+func (e *CaptureEndpoints) MiddleWare(downstream http.HandlerFunc) http.HandlerFunc {
+	// capture disabled
+	if e.size == 0 {
+		return downstream
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		startDate := time.Now()
+		rwi, ok := w.(ResponseWriterInspector)
+		if !ok {
+			rwi = NewResponseWriterInspector(w)
+		}
+		body := &captureBody{
+			body:  r.Body,
+			limit: e.maxBodySize,
+		}
+		r.Body = body
+
+		downstream(rwi, r)
+		duration := time.Since(startDate)
+		body.complete()
+
+		// recording
+		status := rwi.GetStatus()
+		if status == 0 {
+			status = http.StatusOK // implicitly sent by the server
+		}
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		e.record(CapturedRequest{
+			Date:            startDate,
+			Duration:        duration,
+			Method:          r.Method,
+			URL:             scheme + "://" + r.Host + r.URL.RequestURI(),
+			Path:            r.URL.Path,
+			Proto:           r.Proto,
+			Host:            r.Host,
+			RemoteAddr:      r.RemoteAddr,
+			Headers:         r.Header.Clone(),
+			Body:            string(body.captured),
+			BodySize:        max(body.size, r.ContentLength), // the body may not have been fully read
+			BodyTruncated:   body.truncated,
+			Status:          status,
+			ResponseHeaders: rwi.Header().Clone(),
+			ResponseLength:  rwi.GetAnswerLength(),
+		})
+	}
+}
+
+func (e *CaptureEndpoints) record(c CapturedRequest) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.lastID++
+	c.ID = e.lastID
+	if len(e.requests) < e.size {
+		e.requests = append(e.requests, c)
+		return
+	}
+	e.requests[e.next] = c
+	e.next = (e.next + 1) % e.size
+}
+
+// captured returns a copy of captured requests, from the oldest to the newest.
+func (e *CaptureEndpoints) captured() []CapturedRequest {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	requests := make([]CapturedRequest, 0, len(e.requests))
+	requests = append(requests, e.requests[e.next:]...)
+	return append(requests, e.requests[:e.next]...)
+}
+
+// CaptureState describes the requests recorder.
+type CaptureState struct {
+	Size       int   `json:"size"`
+	NbCaptured int   `json:"nb_captured"`
+	LastID     int64 `json:"last_id"`
+}
+
+func (e *CaptureEndpoints) State() CaptureState {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return CaptureState{
+		Size:       e.size,
+		NbCaptured: len(e.requests),
+		LastID:     e.lastID,
+	}
+}
+
+func (e *CaptureEndpoints) List(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	l.Debug("parsing query variables")
+	filter, err := parseCaptureFilter(r.URL.Query())
+	if err != nil {
+		errorString := "failed to parse capture filter"
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Warn(errorString)
+		return
+	}
+	format := r.URL.Query().Get(captureQueryParamFormat)
+	if len(format) == 0 {
+		format = captureFormatText
+	}
+	switch format {
+	case captureFormatText, captureFormatJSON, captureFormatHAR:
+	default:
+		errorString := fmt.Sprintf("unknown format: %q, must be one of: %s, %s, %s", format, captureFormatText, captureFormatJSON, captureFormatHAR)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return
+	}
+
+	// filtering
+	requests := filter.Apply(e.captured())
+	l.Debugf("%d captured request(s) match the filter", len(requests))
+
+	// answer
+	switch format {
+	case captureFormatText:
+		w.WriteHeader(http.StatusOK)
+		if len(requests) == 0 {
+			w.Write([]byte(">>>>> NO CAPTURED REQUEST <<<<<"))
+		}
+		for _, request := range requests {
+			w.Write([]byte(request.String() + "\n"))
+		}
+	case captureFormatJSON:
+		writeJSON(l, w, requests)
+	case captureFormatHAR:
+		entries := make([]HAREntry, 0, len(requests))
+		for _, request := range requests {
+			entries = append(entries, request.HAR())
+		}
+		w.Header().Set("Content-Disposition", `attachment; filename="capture.har"`)
+		writeJSON(l, w, NewHAR(entries))
+	}
+}
+
+func (e *CaptureEndpoints) Reset(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	e.lock.Lock()
+	nbRequests := len(e.requests)
+	e.requests = make([]CapturedRequest, 0, e.size)
+	e.next = 0
+	e.lock.Unlock()
+
+	l.Infof("%d captured request(s) cleared", nbRequests)
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeJSON writes the value as indented JSON in the answer.
+func writeJSON(l *log.Entry, w http.ResponseWriter, v any) {
+	writeJSONStatus(l, w, http.StatusOK, v)
+}
+
+func writeJSONStatus(l *log.Entry, w http.ResponseWriter, status int, v any) {
+	var content bytes.Buffer
+	encoder := json.NewEncoder(&content)
+	encoder.SetEscapeHTML(false) // URLs are easier to read
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		errorString := "failed to marshal answer to JSON"
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Error(errorString)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(content.Bytes())
+}
+
+type captureFilter struct {
+	id          int64
+	path        string
+	method      string
+	headerName  string
+	headerValue string
+	since       time.Time
+	until       time.Time
+	limit       int
+}
+
+func parseCaptureFilter(query url.Values) (f captureFilter, err error) {
+	// id
+	if idString := query.Get(captureQueryParamID); len(idString) > 0 {
+		if f.id, err = strconv.ParseInt(idString, 10, 64); err != nil {
+			return f, errors.WithMessagef(err, "failed to parse %s query param to integer", captureQueryParamID)
+		}
+	}
+	// path & method
+	f.path = query.Get(captureQueryParamPath)
+	f.method = strings.ToUpper(query.Get(captureQueryParamMethod))
+	// header (name or name:value)
+	if header := query.Get(captureQueryParamHeader); len(header) > 0 {
+		name, value, _ := strings.Cut(header, ":")
+		f.headerName = strings.TrimSpace(name)
+		f.headerValue = strings.TrimSpace(value)
+	}
+	// since & until
+	if f.since, err = parseCaptureTime(query.Get(captureQueryParamSince)); err != nil {
+		return f, errors.WithMessagef(err, "failed to parse %s query param", captureQueryParamSince)
+	}
+	if f.until, err = parseCaptureTime(query.Get(captureQueryParamUntil)); err != nil {
+		return f, errors.WithMessagef(err, "failed to parse %s query param", captureQueryParamUntil)
+	}
+	// limit
+	if limitString := query.Get(captureQueryParamLimit); len(limitString) > 0 {
+		if !positiveIntegerRegex.MatchString(limitString) {
+			return f, errors.Errorf("%s query param doesn't match regex: %s", captureQueryParamLimit, positiveIntegerRegex.String())
+		}
+		f.limit, _ = strconv.Atoi(limitString) // can't fail thanks to the regexp
+	}
+	return
+}
+
+// parseCaptureTime parses either an RFC 3339 date, or a Golang duration which
+// is then considered relative to now (i.e.: 5m means 5 minutes ago).
+func parseCaptureTime(s string) (time.Time, error) {
+	if len(s) == 0 {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, errors.Errorf("%q is neither an RFC 3339 date nor a Golang duration", s)
+	}
+	return time.Now().Add(-d), nil
+}
+
+// Apply returns requests matching the filter. If a limit is set, only the
+// most recent requests are returned.
+func (f captureFilter) Apply(requests []CapturedRequest) []CapturedRequest {
+	matching := []CapturedRequest{}
+	for _, request := range requests {
+		if f.Match(request) {
+			matching = append(matching, request)
+		}
+	}
+	if f.limit > 0 && len(matching) > f.limit {
+		matching = matching[len(matching)-f.limit:]
+	}
+	return matching
+}
+
+func (f captureFilter) Match(c CapturedRequest) bool {
+	if f.id > 0 && c.ID != f.id {
+		return false
+	}
+	if len(f.path) > 0 && !strings.HasPrefix(c.Path, f.path) {
+		return false
+	}
+	if len(f.method) > 0 && c.Method != f.method {
+		return false
+	}
+	if len(f.headerName) > 0 {
+		values := c.Headers.Values(f.headerName)
+		if len(values) == 0 {
+			return false
+		}
+		if len(f.headerValue) > 0 {
+			var found bool
+			for _, value := range values {
+				if strings.Contains(value, f.headerValue) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	if !f.since.IsZero() && c.Date.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && c.Date.After(f.until) {
+		return false
+	}
+	return true
+}
+
+// captureBody records the body read by the endpoint, up to a limit.
+type captureBody struct {
+	body      io.ReadCloser
+	captured  []byte
+	limit     int
+	size      int64
+	truncated bool
+	eof       bool
+}
+
+func (b *captureBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.size += int64(n)
+	captured := max(min(n, b.limit-len(b.captured)), 0)
+	b.captured = append(b.captured, p[:captured]...)
+	if captured < n {
+		b.truncated = true
+	}
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+func (b *captureBody) Close() error {
+	return b.body.Close()
+}
+
+// complete reads what the endpoint did not read from the body, up to the
+// limit.
+func (b *captureBody) complete() {
+	if b.eof || len(b.captured) >= b.limit {
+		return
+	}
+	io.CopyN(io.Discard, b, int64(b.limit-len(b.captured)+1)) // +1 to know if truncated
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// form data keys
+	replayFormDataHost         string = "host"
+	replayFormDataSetHeader    string = "set_header"
+	replayFormDataRemoveHeader string = "remove_header"
+	replayFormDataFormat       string = "format"
+)
+
+var (
+	// headers not forwarded when replaying requests
+	replayHopByHopHeaders []string = []string{"Connection", "Content-Length", "Keep-Alive", "Proxy-Authorization", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+)
+
+// ReplayResult compares the response of a captured request with the response
+// of the same request replayed to another target.
+type ReplayResult struct {
+	ID            int64          `json:"id"`
+	Method        string         `json:"method"`
+	BodyTruncated bool           `json:"body_truncated"`
+	Original      ReplayResponse `json:"original"`
+	Replayed      ReplayResponse `json:"replayed"`
+}
+
+type ReplayResponse struct {
+	URL      string        `json:"url"`
+	Status   int           `json:"status"`
+	Length   int64         `json:"length"`
+	Duration time.Duration `json:"duration_ns"`
+	Headers  http.Header   `json:"headers"`
+	Body     string        `json:"body,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Replay sends captured requests to another target, using the same TLS and
+// proxy configuration as the /request endpoint.
+func (e *CaptureEndpoints) Replay(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	// do we have a POST method? (mandatory according to RFC)
+	if r.Method != http.MethodPost {
+		l.Warnf("only the POST method is allowed for posting forms, according to RFC 1867 (%s used)", r.Method)
+	}
+
+	// replay config
+	config, err := parseReplayConfigFromFormData(l, r)
+	if err != nil {
+		errorString := "failed to parse replay config"
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Warn(errorString)
+		return
+	}
+	if err = config.Validate(); err != nil {
+		errorString := "invalid replay config"
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Warn(errorString)
+		return
+	}
+
+	// tls & proxy config
+	transport, err := config.request.GetTransport(l)
+	if err != nil {
+		errorString := "invalid transport configuration"
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Warn(errorString)
+		return
+	}
+	cli := http.Client{
+		Transport: transport,
+		Timeout:   config.request.connectionTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse // redirects are compared, not followed
+		},
+	}
+
+	// captured requests
+	requests := config.filter.Apply(e.captured())
+	if len(requests) == 0 {
+		errorString := "no captured request matches the filter"
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return
+	}
+
+	// replaying
+	l.Infof("replaying %d captured request(s) to %s", len(requests), config.request.url)
+	results := make([]ReplayResult, 0, len(requests))
+	for _, request := range requests {
+		results = append(results, config.replay(l, cli, request))
+	}
+
+	// answer
+	if config.format == captureFormatJSON {
+		writeJSON(l, w, results)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	for _, result := range results {
+		writeReplayResult(w, result, config.request.echoHeaders, config.request.echoBody)
+	}
+}
+
+func (c replayConfig) replay(l *log.Entry, cli http.Client, captured CapturedRequest) (result ReplayResult) {
+	result = ReplayResult{
+		ID:            captured.ID,
+		Method:        captured.Method,
+		BodyTruncated: captured.BodyTruncated,
+		Original: ReplayResponse{
+			URL:      captured.URL,
+			Status:   captured.Status,
+			Length:   captured.ResponseLength,
+			Duration: captured.Duration,
+			Headers:  captured.ResponseHeaders,
+		},
+	}
+	l = l.WithField("replayed_id", captured.ID)
+
+	// target URL
+	requestURI := captured.Path
+	if u, err := url.Parse(captured.URL); err == nil {
+		requestURI = u.RequestURI()
+	}
+	result.Replayed.URL = strings.TrimSuffix(c.request.url, "/") + requestURI
+
+	// request
+	request, err := http.NewRequest(captured.Method, result.Replayed.URL, strings.NewReader(captured.Body))
+	if err != nil {
+		result.Replayed.Error = "failed to create request: " + err.Error()
+		l.WithError(err).Warn("failed to create request")
+		return
+	}
+	request.Header = captured.Headers.Clone()
+	for _, header := range replayHopByHopHeaders {
+		request.Header.Del(header)
+	}
+	for _, header := range c.removeHeaders {
+		request.Header.Del(header)
+	}
+	for name, values := range c.setHeaders {
+		request.Header[name] = values
+	}
+	if len(c.host) > 0 {
+		request.Host = c.host
+	}
+	if captured.BodyTruncated {
+		l.Warnf("body of captured request has been truncated to %d Bytes", len(captured.Body))
+	}
+
+	// sending
+	startDate := time.Now()
+	answer, err := cli.Do(request)
+	if err != nil {
+		result.Replayed.Error = "failed to perform the request: " + err.Error()
+		l.WithError(err).Warn("failed to perform the request")
+		return
+	}
+	defer answer.Body.Close()
+	var body strings.Builder
+	if c.request.echoBody {
+		result.Replayed.Length, err = io.Copy(&body, answer.Body)
+	} else {
+		result.Replayed.Length, err = io.Copy(io.Discard, answer.Body)
+	}
+	result.Replayed.Duration = time.Since(startDate)
+	result.Replayed.Status = answer.StatusCode
+	result.Replayed.Headers = answer.Header
+	result.Replayed.Body = body.String()
+	if err != nil {
+		result.Replayed.Error = "failed to read the answer body: " + err.Error()
+		l.WithError(err).Warn("failed to read the answer body")
+		return
+	}
+	l.Infof("request replayed to %s, status_code:%d (original: %d)", result.Replayed.URL, result.Replayed.Status, result.Original.Status)
+	return
+}
+
+// writeReplayResult writes the original and replayed responses side by side.
+func writeReplayResult(w http.ResponseWriter, result ReplayResult, echoHeaders, echoBody bool) {
+	w.Write([]byte(fmt.Sprintf("--- REPLAY OF #%d: %s\n", result.ID, result.Method)))
+	if result.BodyTruncated {
+		w.Write([]byte("warning: the captured request body was truncated\n"))
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "\toriginal\treplayed\n")
+	fmt.Fprintf(tw, "url\t%s\t%s\n", result.Original.URL, result.Replayed.URL)
+	if len(result.Replayed.Error) > 0 {
+		fmt.Fprintf(tw, "error\t\t%s\n", result.Replayed.Error)
+	} else {
+		fmt.Fprintf(tw, "status\t%d\t%d\n", result.Original.Status, result.Replayed.Status)
+		fmt.Fprintf(tw, "length\t%d\t%d\n", result.Original.Length, result.Replayed.Length)
+		fmt.Fprintf(tw, "timing\t%s\t%s\n", result.Original.Duration.String(), result.Replayed.Duration.String())
+	}
+	if echoHeaders {
+		// union of header names
+		names := map[string]bool{}
+		for name := range result.Original.Headers {
+			names[name] = true
+		}
+		for name := range result.Replayed.Headers {
+			names[name] = true
+		}
+		sortedNames := make([]string, 0, len(names))
+		for name := range names {
+			sortedNames = append(sortedNames, name)
+		}
+		sort.Strings(sortedNames)
+		for _, name := range sortedNames {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", name, strings.Join(result.Original.Headers.Values(name), ", "), strings.Join(result.Replayed.Headers.Values(name), ", "))
+		}
+	}
+	tw.Flush()
+	if echoBody && len(result.Replayed.Error) == 0 {
+		w.Write([]byte("--- REPLAYED ANSWER BODY\n"))
+		w.Write([]byte(result.Replayed.Body + "\n"))
+	}
+	w.Write([]byte("\n"))
+}
+
+type replayConfig struct {
+	request       requestConfig
+	filter        captureFilter
+	host          string
+	setHeaders    http.Header
+	removeHeaders []string
+	format        string
+}
+
+func parseReplayConfigFromFormData(l *log.Entry, r *http.Request) (c replayConfig, err error) {
+	l.Debug("parsing replay configuration")
+	// target, tls & proxy (also parses the form)
+	if c.request, err = parseRequestConfigFromFormData(l, r); err != nil {
+		return
+	}
+	// captured requests filter (from both query params and form values)
+	if c.filter, err = parseCaptureFilter(r.Form); err != nil {
+		return c, errors.WithMessage(err, "failed to parse capture filter")
+	}
+
+	c.host = strings.TrimSpace(r.FormValue(replayFormDataHost))
+	c.format = strings.TrimSpace(r.FormValue(replayFormDataFormat))
+	if len(c.format) == 0 {
+		c.format = captureFormatText
+	}
+	// headers
+	c.setHeaders = http.Header{}
+	for _, header := range r.Form[replayFormDataSetHeader] {
+		name, value, found := strings.Cut(header, ":")
+		if !found {
+			return c, errors.Errorf("header %q must be formatted as 'Name: value'", header)
+		}
+		c.setHeaders.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	for _, header := range r.Form[replayFormDataRemoveHeader] {
+		c.removeHeaders = append(c.removeHeaders, strings.TrimSpace(header))
+	}
+	return
+}
+
+func (c replayConfig) Validate() error {
+	if err := c.request.Validate(); err != nil {
+		return err
+	}
+	// only HTTP requests can be replayed
+	if !strings.HasPrefix(c.request.url, "http://") && !strings.HasPrefix(c.request.url, "https://") {
+		return errors.New("the URL must start with one of following schemes: http://, https://")
+	}
+	// target URL must not contain query
+	if strings.Contains(c.request.url, "?") {
+		return errors.New("the URL must not contain a query string, captured requests' one is used")
+	}
+	// format
+	switch c.format {
+	case captureFormatText, captureFormatJSON:
+	default:
+		return errors.Errorf("unknown format: %q, must be one of: %s, %s", c.format, captureFormatText, captureFormatJSON)
+	}
+	// headers
+	for name := range c.setHeaders {
+		if len(name) == 0 {
+			return errors.New("header name can't be empty")
+		}
+	}
+	return nil
+}
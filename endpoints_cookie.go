@@ -0,0 +1,291 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// params
+	cookieQueryParamName     string = "name"
+	cookieQueryParamValue    string = "value"
+	cookieQueryParamDomain   string = "domain"
+	cookieQueryParamPath     string = "path"
+	cookieQueryParamSameSite string = "same_site"
+	cookieQueryParamSecure   string = "secure"
+	cookieQueryParamHTTPOnly string = "http_only"
+	cookieQueryParamMaxAge   string = "max_age"
+
+	// defaults
+	cookieDefaultSessionName string = "itw_session"
+
+	// number of sessions counted, the least recently used are forgotten
+	cookieMaxSessions int = 10000
+)
+
+var (
+	cookieSameSites = map[string]http.SameSite{
+		"lax":    http.SameSiteLaxMode,
+		"strict": http.SameSiteStrictMode,
+		"none":   http.SameSiteNoneMode,
+	}
+)
+
+type CookieEndpoints struct {
+	lock     *sync.Mutex
+	hostname string
+	sessions map[string]*list.Element // by session ID, in the LRU list
+	lru      *list.List               // of *cookieSession, most recently used first
+}
+
+// cookieSession counts the requests received for a session.
+type cookieSession struct {
+	id       string
+	requests int
+}
+
+func NewCookieEndpoints() *CookieEndpoints {
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.WithError(err).Warn("failed to get hostname")
+	}
+	return &CookieEndpoints{
+		lock:     &sync.Mutex{},
+		hostname: hostname,
+		sessions: map[string]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+// CookieState describes a cookie sent by the client.
+type CookieState struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// SessionState describes the session of the client, as seen by the instance
+// which answered.
+type SessionState struct {
+	Hostname   string `json:"hostname"`
+	InstanceID string `json:"instance_id"`
+	Session    string `json:"session"`
+	IssuedBy   string `json:"issued_by"` // ID of the instance which issued the session
+	New        bool   `json:"new"`
+	Sticky     bool   `json:"sticky"`   // false if the session was issued by another instance
+	Requests   int    `json:"requests"` // requests of the session received by the instance
+}
+
+// Set asks the client to set a cookie, with the given attributes.
+func (e *CookieEndpoints) Set(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	cookie, err := parseCookie(query)
+	if err == nil && len(cookie.Name) == 0 {
+		err = errors.New("name is mandatory")
+	}
+	if err == nil {
+		cookie.Value = query.Get(cookieQueryParamValue)
+		err = cookie.Valid()
+	}
+	if err != nil {
+		errorString := "invalid cookie"
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Warn(errorString)
+		return
+	}
+
+	l.Infof("setting cookie: %s", cookie.String())
+	http.SetCookie(w, cookie)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Set-Cookie: " + cookie.String() + "\n"))
+}
+
+// List returns the cookies sent by the client.
+func (e *CookieEndpoints) List(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	format, ok := parseFormat(l, w, r.URL.Query())
+	if !ok {
+		return
+	}
+	cookies := []CookieState{}
+	for _, cookie := range r.Cookies() {
+		cookies = append(cookies, CookieState{Name: cookie.Name, Value: cookie.Value})
+	}
+	sort.SliceStable(cookies, func(i, j int) bool { return cookies[i].Name < cookies[j].Name })
+
+	l.Infof("%d cookie(s) received", len(cookies))
+	if format == echoFormatJSON {
+		writeJSON(l, w, cookies)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	for _, cookie := range cookies {
+		w.Write([]byte(cookie.Name + "=" + cookie.Value + "\n"))
+	}
+}
+
+// Delete asks the client to delete the given cookies, or all the cookies it
+// sent if none is given.
+func (e *CookieEndpoints) Delete(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	cookie, err := parseCookie(query)
+	if err != nil {
+		errorString := "invalid cookie"
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Warn(errorString)
+		return
+	}
+	names := query[cookieQueryParamName]
+	if len(names) == 0 {
+		for _, received := range r.Cookies() {
+			names = append(names, received.Name)
+		}
+	}
+
+	cookie.MaxAge = -1 // Max-Age=0
+	deleted := []string{}
+	for _, name := range names {
+		deletion := *cookie
+		deletion.Name = name
+		if err := deletion.Valid(); err != nil {
+			l.WithError(err).Warnf("skipping invalid cookie %q", name)
+			continue
+		}
+		http.SetCookie(w, &deletion)
+		deleted = append(deleted, "Set-Cookie: "+deletion.String())
+	}
+	l.Infof("deleting %d cookie(s)", len(deleted))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(strings.Join(append(deleted, ""), "\n")))
+}
+
+// Session issues a session cookie, or counts the requests of the session if
+// the client already has one. The session cookie contains the ID of the
+// instance which issued it, to tell whether the session is sticky.
+func (e *CookieEndpoints) Session(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format, ok := parseFormat(l, w, query)
+	if !ok {
+		return
+	}
+	cookie, err := parseCookie(query)
+	if err != nil {
+		errorString := "invalid cookie"
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Warn(errorString)
+		return
+	}
+	if len(cookie.Name) == 0 {
+		cookie.Name = cookieDefaultSessionName
+	}
+	if len(cookie.Path) == 0 {
+		cookie.Path = "/"
+	}
+
+	// session
+	state := SessionState{
+		Hostname:   e.hostname,
+		InstanceID: InstanceID,
+	}
+	if received, err := r.Cookie(cookie.Name); err == nil {
+		state.IssuedBy, state.Session, _ = strings.Cut(received.Value, ".")
+	}
+	if len(state.Session) == 0 {
+		state.IssuedBy, state.Session, state.New = InstanceID, GenerateUUID(), true
+		cookie.Value = state.IssuedBy + "." + state.Session
+		if err := cookie.Valid(); err != nil {
+			errorString := "invalid cookie"
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString + ": " + err.Error()))
+			l.WithError(err).Warn(errorString)
+			return
+		}
+		http.SetCookie(w, cookie)
+	}
+	state.Sticky = state.IssuedBy == InstanceID
+	state.Requests = e.countRequest(state.Session)
+
+	l.Infof("session %s (issued by %s, new: %t): %d request(s)", state.Session, state.IssuedBy, state.New, state.Requests)
+	if format == echoFormatJSON {
+		writeJSON(l, w, state)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("hostname: " + state.Hostname + "\n"))
+	w.Write([]byte("instance ID: " + state.InstanceID + "\n"))
+	w.Write([]byte("session: " + state.Session + "\n"))
+	w.Write([]byte("issued by: " + state.IssuedBy + "\n"))
+	w.Write([]byte("new: " + strconv.FormatBool(state.New) + "\n"))
+	w.Write([]byte("sticky: " + strconv.FormatBool(state.Sticky) + "\n"))
+	w.Write([]byte("requests: " + strconv.Itoa(state.Requests) + "\n"))
+}
+
+// countRequest counts a request of the session, and returns the number of
+// requests received. Beyond cookieMaxSessions, the least recently used
+// session is forgotten.
+func (e *CookieEndpoints) countRequest(id string) int {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	element, found := e.sessions[id]
+	if found {
+		e.lru.MoveToFront(element)
+	} else {
+		element = e.lru.PushFront(&cookieSession{id: id})
+		e.sessions[id] = element
+		if e.lru.Len() > cookieMaxSessions {
+			delete(e.sessions, e.lru.Remove(e.lru.Back()).(*cookieSession).id)
+		}
+	}
+	session := element.Value.(*cookieSession)
+	session.requests++
+	return session.requests
+}
+
+// parseCookie parses the cookie name and attributes. The value is not parsed.
+func parseCookie(query url.Values) (cookie *http.Cookie, err error) {
+	cookie = &http.Cookie{
+		Name:   query.Get(cookieQueryParamName),
+		Domain: query.Get(cookieQueryParamDomain),
+		Path:   query.Get(cookieQueryParamPath),
+	}
+	// same site
+	if sameSiteString := query.Get(cookieQueryParamSameSite); len(sameSiteString) > 0 {
+		var found bool
+		if cookie.SameSite, found = cookieSameSites[strings.ToLower(sameSiteString)]; !found {
+			return nil, errors.Errorf("unknown same site: %q, must be one of: lax, strict, none", sameSiteString)
+		}
+	}
+	// secure
+	if secureString := query.Get(cookieQueryParamSecure); len(secureString) > 0 {
+		if cookie.Secure, err = strconv.ParseBool(secureString); err != nil {
+			return nil, errors.WithMessage(err, "failed to parse secure to boolean")
+		}
+	}
+	// http only
+	if httpOnlyString := query.Get(cookieQueryParamHTTPOnly); len(httpOnlyString) > 0 {
+		if cookie.HttpOnly, err = strconv.ParseBool(httpOnlyString); err != nil {
+			return nil, errors.WithMessage(err, "failed to parse http only to boolean")
+		}
+	}
+	// max age
+	if maxAgeString := query.Get(cookieQueryParamMaxAge); len(maxAgeString) > 0 {
+		if !positiveIntegerRegex.MatchString(maxAgeString) {
+			return nil, errors.Errorf("max age doesn't match regex: %s (value: %s)", positiveIntegerRegex.String(), maxAgeString)
+		}
+		cookie.MaxAge, _ = strconv.Atoi(maxAgeString) // can't fail thanks to the regexp
+		if cookie.MaxAge == 0 {
+			cookie.MaxAge = -1 // Max-Age=0
+		}
+	}
+	return cookie, nil
+}
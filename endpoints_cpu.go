@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -19,6 +20,19 @@ type CPUEndpoints struct {
 	lock      *sync.Mutex
 	stopFuncs []func()
 	workers   *sync.WaitGroup
+	loads     []CPULoadState
+	stateNotifier
+}
+
+// CPUState describes the load workers currently running.
+type CPUState struct {
+	Loads []CPULoadState `json:"loads"`
+}
+
+type CPULoadState struct {
+	Started   time.Time `json:"started"`
+	NbThreads int       `json:"nb_threads"`
+	Timeout   string    `json:"timeout"`
 }
 
 func NewCPUEndpoints() *CPUEndpoints {
@@ -70,10 +84,16 @@ func (e *CPUEndpoints) Load(l *log.Entry, w http.ResponseWriter, r *http.Request
 	}
 
 	// starting workers
+	l.Infof("starting %d load workers, with timeout of %s", nbThreads, timeout.String())
+	e.start(nbThreads, timeout)
+	e.changed()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (e *CPUEndpoints) start(nbThreads int, timeout time.Duration) {
 	e.lock.Lock()
 	defer e.lock.Unlock()
 	e.workers.Add(nbThreads)
-	l.Infof("starting %d load workers, with timeout of %s", nbThreads, timeout.String())
 	for range nbThreads {
 		stopCh := make(chan int)
 		e.stopFuncs = append(e.stopFuncs, func() { stopCh <- 0 })
@@ -94,7 +114,11 @@ func (e *CPUEndpoints) Load(l *log.Entry, w http.ResponseWriter, r *http.Request
 			}
 		}(timeout, stopCh)
 	}
-	w.WriteHeader(http.StatusOK)
+	e.loads = append(e.loads, CPULoadState{
+		Started:   time.Now(),
+		NbThreads: nbThreads,
+		Timeout:   timeout.String(),
+	})
 }
 
 func (e *CPUEndpoints) Reset(l *log.Entry, w http.ResponseWriter, r *http.Request) {
@@ -104,9 +128,31 @@ func (e *CPUEndpoints) Reset(l *log.Entry, w http.ResponseWriter, r *http.Reques
 		go stopFunc()
 	}
 	e.stopFuncs = []func(){}
+	e.loads = nil
 	e.lock.Unlock()
 	e.workers.Wait()
 	l.Info("load workers stopped")
+	e.changed()
 
 	w.WriteHeader(http.StatusOK)
 }
+
+func (e *CPUEndpoints) State() CPUState {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return CPUState{
+		Loads: append([]CPULoadState{}, e.loads...),
+	}
+}
+
+// Restore starts the load workers described by the state.
+func (e *CPUEndpoints) Restore(state CPUState) error {
+	for i, load := range state.Loads {
+		timeout, err := time.ParseDuration(load.Timeout)
+		if err != nil {
+			return errors.WithMessagef(err, "failed to parse timeout of load %d", i+1)
+		}
+		e.start(load.NbThreads, timeout)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type CrashEndpoints struct {
+	lock    *sync.Mutex
+	pending []CrashState
+	onExit  func() // called right before crashing, i.e.: to save the state
+}
+
+func NewCrashEndpoints() *CrashEndpoints {
+	return &CrashEndpoints{
+		lock: &sync.Mutex{},
+	}
+}
+
+// OnExit sets the function called right before crashing.
+func (e *CrashEndpoints) OnExit(onExit func()) {
+	e.onExit = onExit
+}
+
+// CrashState describes a pending crash.
+type CrashState struct {
+	Date     time.Time `json:"date"`
+	ExitCode int       `json:"exit_code"`
+}
+
+func (e *CrashEndpoints) Crash(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	// default exit code
+	exitCode := 1
+
+	// parsing exit code
+	var err error
+	exitCodeString := r.URL.Query().Get(queryParamCode)
+	if len(exitCodeString) > 0 {
+		if exitCode, err = strconv.Atoi(exitCodeString); err != nil {
+			errorString := fmt.Sprintf("query param %s is not an integer", queryParamCode)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString + ": " + err.Error()))
+			l.WithError(err).Warn(errorString)
+			return
+		} else if exitCode < 0 {
+			errorString := fmt.Sprintf("query param %s is inferior to 0 (value: %d)", queryParamCode, exitCode)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+	}
+	// parsing timeout
+	timeout := time.Second
+	timeoutString := r.URL.Query().Get(queryParamTimeout)
+	if len(timeoutString) > 0 {
+		timeout, err = time.ParseDuration(timeoutString)
+		if err != nil {
+			errorString := "timeout is incorrect: " + err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		} else if timeout < 0 {
+			errorString := "timeout is inferior to zero: " + timeout.String()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+	}
+	e.lock.Lock()
+	e.pending = append(e.pending, CrashState{
+		Date:     time.Now().Add(timeout),
+		ExitCode: exitCode,
+	})
+	e.lock.Unlock()
+	w.WriteHeader(http.StatusOK)
+	l.Infof("server will crash in %s with exit code: %d", timeout.String(), exitCode)
+
+	// crash
+	go func(exitCode int) {
+		time.Sleep(timeout)
+		if e.onExit != nil {
+			e.onExit()
+		}
+		syscall.Exit(exitCode)
+	}(exitCode)
+}
+
+// State returns the pending crashes, ordered by date.
+func (e *CrashEndpoints) State() []CrashState {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	state := append([]CrashState{}, e.pending...)
+	sort.Slice(state, func(i, j int) bool { return state[i].Date.Before(state[j].Date) })
+	return state
+}
@@ -4,8 +4,11 @@ import (
 	"database/sql"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -38,10 +41,55 @@ const (
 	databaseFormDataQuery    string = "query"
 )
 
-type DatabaseEndpoints struct{}
+type DatabaseEndpoints struct {
+	lock   *sync.Mutex
+	pools  map[int64]databasePool // pools currently opened by requests
+	lastID int64
+}
 
 func NewDatabaseEndpoints() *DatabaseEndpoints {
-	return &DatabaseEndpoints{}
+	return &DatabaseEndpoints{
+		lock:  &sync.Mutex{},
+		pools: map[int64]databasePool{},
+	}
+}
+
+type databasePool struct {
+	db    *sql.DB
+	state DatabasePoolState
+}
+
+// DatabasePoolState describes a database connection pool opened by a request
+// still being processed.
+type DatabasePoolState struct {
+	ID              int64     `json:"id"`
+	Opened          time.Time `json:"opened"`
+	Engine          string    `json:"engine"`
+	Host            string    `json:"host"`
+	Port            int       `json:"port"`
+	DBName          string    `json:"db_name"`
+	Username        string    `json:"username"`
+	OpenConnections int       `json:"open_connections"`
+	InUse           int       `json:"in_use"`
+	Idle            int       `json:"idle"`
+}
+
+// State returns the database pools currently opened, ordered by ID.
+func (e *DatabaseEndpoints) State() []DatabasePoolState {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	state := make([]DatabasePoolState, 0, len(e.pools))
+	for _, pool := range e.pools {
+		poolState := pool.state
+		stats := pool.db.Stats()
+		poolState.OpenConnections = stats.OpenConnections
+		poolState.InUse = stats.InUse
+		poolState.Idle = stats.Idle
+		state = append(state, poolState)
+	}
+	sort.Slice(state, func(i, j int) bool { return state[i].ID < state[j].ID })
+	return state
 }
 
 func (e *DatabaseEndpoints) Connect(l *log.Entry, w http.ResponseWriter, r *http.Request) {
@@ -84,7 +132,7 @@ func (e *DatabaseEndpoints) Connect(l *log.Entry, w http.ResponseWriter, r *http
 	if db == nil {
 		return // error already sent
 	}
-	defer db.Close()
+	defer e.close(l, db)
 
 	// testing connection
 	if err = db.Ping(); err != nil {
@@ -147,7 +195,7 @@ func (e *DatabaseEndpoints) Query(l *log.Entry, w http.ResponseWriter, r *http.R
 	if db == nil {
 		return // error already sent
 	}
-	defer db.Close()
+	defer e.close(l, db)
 
 	// running query
 	l.Debugf("running query: %s", query)
@@ -195,9 +243,40 @@ func (d *DatabaseEndpoints) open(l *log.Entry, w http.ResponseWriter, config dbC
 		l.WithError(err).Warn(errorString)
 		return nil
 	}
+
+	// tracking pool
+	d.lock.Lock()
+	d.lastID++
+	d.pools[d.lastID] = databasePool{
+		db: db,
+		state: DatabasePoolState{
+			ID:       d.lastID,
+			Opened:   time.Now(),
+			Engine:   config.engine,
+			Host:     config.host,
+			Port:     config.port,
+			DBName:   config.dbName,
+			Username: config.username,
+		},
+	}
+	d.lock.Unlock()
 	return db
 }
 
+func (d *DatabaseEndpoints) close(l *log.Entry, db *sql.DB) {
+	d.lock.Lock()
+	for id, pool := range d.pools {
+		if pool.db == db {
+			delete(d.pools, id)
+			break
+		}
+	}
+	d.lock.Unlock()
+	if err := db.Close(); err != nil {
+		l.WithError(err).Warn("failed to close database connection")
+	}
+}
+
 type dbConfig struct {
 	engine    string
 	host      string
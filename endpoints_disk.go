@@ -0,0 +1,640 @@
+package main
+
+import (
+	cryptorand "crypto/rand"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// params
+	diskQueryParamPercentage string = "percentage"
+	diskQueryParamFrequency  string = "frequency"
+	diskQueryParamMode       string = "mode"
+	diskQueryParamBlockSize  string = "block_size"
+	diskQueryParamFileSize   string = "file_size"
+	diskQueryParamIOPS       string = "iops"
+	diskQueryParamFsync      string = "fsync"
+
+	// I/O modes
+	diskIOModeRead  string = "read"
+	diskIOModeWrite string = "write"
+	diskIOModeMixed string = "mixed"
+
+	// files prefixes
+	diskFillFilePrefix string = "fill-"
+	diskLeakFilePrefix string = "leak-"
+	diskIOFilePrefix   string = "io-"
+
+	// defaults
+	diskDefaultBlockSize int   = 4 * 1024         // 4KiB
+	diskDefaultFileSize  int64 = 64 * 1024 * 1024 // 64MiB
+	diskDataSize         int   = 1024 * 1024      // 1MiB
+)
+
+type DiskEndpoints struct {
+	lock      *sync.Mutex
+	folder    string
+	data      []byte // random, so the written data can't be compressed
+	stopFuncs []func()
+	workerID  int
+	workers   *sync.WaitGroup
+	leakers   []DiskLeakWorkerState
+	ioWorkers map[int]*diskIOWorker
+}
+
+// DiskState describes the files written by the server, the filesystem usage,
+// and the workers currently running.
+type DiskState struct {
+	Folder      string                `json:"folder"`
+	Used        int64                 `json:"used"`
+	UsedHuman   string                `json:"used_human"`
+	NbFiles     int                   `json:"nb_files"`
+	Filesystem  DiskFilesystemState   `json:"filesystem"`
+	LeakWorkers []DiskLeakWorkerState `json:"leak_workers"`
+	IOWorkers   []DiskIOWorkerState   `json:"io_workers"`
+}
+
+// DiskFilesystemState is the usage of the filesystem of the folder, as
+// reported by df.
+type DiskFilesystemState struct {
+	Size        uint64  `json:"size"`
+	Used        uint64  `json:"used"`
+	Available   uint64  `json:"available"`
+	UsedPercent float64 `json:"used_percent"`
+}
+
+type DiskLeakWorkerState struct {
+	ID        int       `json:"id"`
+	Started   time.Time `json:"started"`
+	Size      int64     `json:"size"`
+	Frequency string    `json:"frequency"`
+}
+
+type DiskIOWorkerState struct {
+	ID        int       `json:"id"`
+	Started   time.Time `json:"started"`
+	Mode      string    `json:"mode"`
+	BlockSize int       `json:"block_size"`
+	FileSize  int64     `json:"file_size"`
+	IOPS      int       `json:"iops"`
+	Fsync     bool      `json:"fsync"`
+	Timeout   string    `json:"timeout"`
+	NbReads   int64     `json:"nb_reads"`
+	NbWrites  int64     `json:"nb_writes"`
+}
+
+// diskIOWorker generates I/O operations on a file, the operation counters
+// are updated while the worker runs.
+type diskIOWorker struct {
+	state    DiskIOWorkerState
+	nbReads  *atomic.Int64
+	nbWrites *atomic.Int64
+}
+
+func NewDiskEndpoints(folder string) *DiskEndpoints {
+	data := make([]byte, diskDataSize)
+	var seed [32]byte
+	cryptorand.Read(seed[:])
+	rand.NewChaCha8(seed).Read(data)
+	return &DiskEndpoints{
+		lock:      &sync.Mutex{},
+		folder:    folder,
+		data:      data,
+		workers:   &sync.WaitGroup{},
+		ioWorkers: map[int]*diskIOWorker{},
+	}
+}
+
+func (e *DiskEndpoints) Fill(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	l.Debug("parsing query variables")
+	// parsing size
+	size := int64(size1MiB)
+	sizeString := r.URL.Query().Get(queryParamSize)
+	if len(sizeString) > 0 {
+		var err error
+		if size, err = parseSize(sizeString); err != nil {
+			errorString := err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+	}
+	// parsing percentage
+	percentageString := r.URL.Query().Get(diskQueryParamPercentage)
+	if len(percentageString) > 0 {
+		if len(sizeString) > 0 {
+			errorString := "size and percentage can't be set together"
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+		percentage, err := strconv.ParseFloat(percentageString, 64)
+		if err != nil || percentage < 0 || percentage > 100 {
+			errorString := fmt.Sprintf("percentage must be a number between 0 and 100 (value: %s)", percentageString)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+		filesystem, err := diskFilesystemState(e.folder)
+		if err != nil {
+			errorString := "failed to get filesystem usage"
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(errorString + ": " + err.Error()))
+			l.WithError(err).Error(errorString)
+			return
+		}
+		size = int64(percentage/100*float64(filesystem.Used+filesystem.Available)) - int64(filesystem.Used)
+		if size <= 0 {
+			answerText := fmt.Sprintf("filesystem usage (%.2f%%) is already above %.2f%%\n%s", filesystem.UsedPercent, percentage, e.diskStats())
+			l.Info(answerText)
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(answerText))
+			return
+		}
+	}
+
+	// filling the disk
+	l.Infof("filling disk with %s (%d Bytes)", SizeToHumanReadable(float64(size)), size)
+	written, err := e.writeFile(diskFillFilePrefix, size)
+	diskStats := e.diskStats()
+	if err != nil {
+		errorString := fmt.Sprintf("failed to fill disk with %s (%d Bytes), only %s (%d Bytes) written: %s", SizeToHumanReadable(float64(size)), size, SizeToHumanReadable(float64(written)), written, err.Error())
+		w.WriteHeader(http.StatusInsufficientStorage)
+		w.Write([]byte(errorString + "\n" + diskStats))
+		l.Warn(errorString)
+		return
+	}
+	l.Info(diskStats)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(diskStats))
+}
+
+func (e *DiskEndpoints) Leak(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	l.Debug("parsing query variables")
+	// parsing size
+	size := int64(size1MiB)
+	if sizeString := r.URL.Query().Get(queryParamSize); len(sizeString) > 0 {
+		var err error
+		if size, err = parseSize(sizeString); err != nil {
+			errorString := err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+	}
+
+	// parsing frequency
+	leakFrequencyString := r.URL.Query().Get(diskQueryParamFrequency)
+	var err error
+	var leakFrequency time.Duration
+	if len(leakFrequencyString) > 0 {
+		leakFrequency, err = time.ParseDuration(leakFrequencyString)
+		if err != nil {
+			errorString := "leak frequency is incorrect: " + err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		} else if leakFrequency < 0 {
+			errorString := "leak frequency is inferior to zero: " + leakFrequency.String()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+	}
+
+	// leak worker
+	e.startLeakWorker(size, leakFrequency)
+	l.Infof("starting disk leak with frequency of %s/%s", SizeToHumanReadable(float64(size)), leakFrequency.String())
+	w.WriteHeader(http.StatusOK)
+}
+
+func (e *DiskEndpoints) startLeakWorker(size int64, leakFrequency time.Duration) {
+	// stop
+	stopCh := make(chan struct{})
+	e.lock.Lock()
+	e.stopFuncs = append(e.stopFuncs, sync.OnceFunc(func() { close(stopCh) }))
+	e.workerID++
+	workerID := e.workerID
+	e.leakers = append(e.leakers, DiskLeakWorkerState{
+		ID:        workerID,
+		Started:   time.Now(),
+		Size:      size,
+		Frequency: leakFrequency.String(),
+	})
+	e.lock.Unlock()
+
+	// leak worker
+	e.workers.Add(1)
+	go func() {
+		defer e.workers.Done()
+
+		// logger
+		l := log.WithField("disk_leak_worker_id", workerID)
+		if leakFrequency > 0 {
+			l = l.WithField("frequency", leakFrequency.String())
+		}
+
+		// worker loop
+		for {
+			select {
+			case <-stopCh:
+				return
+
+			default:
+				if _, err := e.writeFile(diskLeakFilePrefix, size); err != nil {
+					l.WithError(err).Error("failed to leak disk space, stopping the leak worker")
+					return // the worker is still reported until reset
+				}
+				l.Info(e.diskStats())
+				if leakFrequency > 0 {
+					select {
+					case <-stopCh:
+						return
+					case <-time.After(leakFrequency):
+					}
+				}
+			}
+		}
+	}()
+}
+
+func (e *DiskEndpoints) IO(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	l.Debug("parsing query variables")
+	query := r.URL.Query()
+	// mode
+	mode := query.Get(diskQueryParamMode)
+	if len(mode) == 0 {
+		mode = diskIOModeWrite
+	} else if mode != diskIOModeRead && mode != diskIOModeWrite && mode != diskIOModeMixed {
+		errorString := fmt.Sprintf("unknown mode: %q, must be one of: %s, %s, %s", mode, diskIOModeRead, diskIOModeWrite, diskIOModeMixed)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return
+	}
+	// block size
+	blockSize := diskDefaultBlockSize
+	if blockSizeString := query.Get(diskQueryParamBlockSize); len(blockSizeString) > 0 {
+		if !positiveIntegerRegex.MatchString(blockSizeString) {
+			errorString := "block size doesn't match regex: " + positiveIntegerRegex.String()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+		var err error
+		if blockSize, err = strconv.Atoi(blockSizeString); err != nil || blockSize < 1 || blockSize > diskDataSize {
+			errorString := fmt.Sprintf("block size must be between 1 and %d", diskDataSize)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+	}
+	// file size
+	fileSize := diskDefaultFileSize
+	if fileSizeString := query.Get(diskQueryParamFileSize); len(fileSizeString) > 0 {
+		var err error
+		if fileSize, err = parseSize(fileSizeString); err != nil {
+			errorString := "file " + err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+	}
+	if fileSize < int64(blockSize) {
+		errorString := fmt.Sprintf("file size (%d) is inferior to the block size (%d)", fileSize, blockSize)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return
+	}
+	// iops
+	var iops int
+	if iopsString := query.Get(diskQueryParamIOPS); len(iopsString) > 0 {
+		if !positiveIntegerRegex.MatchString(iopsString) {
+			errorString := "iops doesn't match regex: " + positiveIntegerRegex.String()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+		iops, _ = strconv.Atoi(iopsString) // can't fail thanks to the regexp
+	}
+	// fsync
+	var fsync bool
+	if fsyncString := query.Get(diskQueryParamFsync); len(fsyncString) > 0 {
+		var err error
+		if fsync, err = strconv.ParseBool(fsyncString); err != nil {
+			errorString := "fsync is incorrect: " + err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+	}
+	// number of workers
+	nbThreads := 1
+	if nbThreadsString := query.Get(queryParamNbTheads); len(nbThreadsString) > 0 {
+		if !positiveIntegerRegex.MatchString(nbThreadsString) {
+			errorString := "number of threads doesn't match regex: " + positiveIntegerRegex.String()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+		var err error
+		if nbThreads, err = strconv.Atoi(nbThreadsString); err != nil || nbThreads < 1 {
+			errorString := "number of threads must be at least 1"
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+	}
+	// timeout
+	var timeout time.Duration
+	if timeoutString := query.Get(queryParamTimeout); len(timeoutString) > 0 {
+		var err error
+		if timeout, err = time.ParseDuration(timeoutString); err != nil {
+			errorString := "timeout is incorrect: " + err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		} else if timeout < 0 {
+			errorString := "timeout is inferior to zero: " + timeout.String()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+	}
+
+	// starting workers
+	l.Infof("starting %d disk I/O workers (%s, block size: %d, file size: %s, iops: %d, fsync: %t), with timeout of %s", nbThreads, mode, blockSize, SizeToHumanReadable(float64(fileSize)), iops, fsync, timeout.String())
+	for range nbThreads {
+		e.startIOWorker(DiskIOWorkerState{
+			Started:   time.Now(),
+			Mode:      mode,
+			BlockSize: blockSize,
+			FileSize:  fileSize,
+			IOPS:      iops,
+			Fsync:     fsync,
+			Timeout:   timeout.String(),
+		}, timeout)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (e *DiskEndpoints) startIOWorker(state DiskIOWorkerState, timeout time.Duration) {
+	// stop
+	stopCh := make(chan struct{})
+	e.lock.Lock()
+	e.stopFuncs = append(e.stopFuncs, sync.OnceFunc(func() { close(stopCh) }))
+	e.workerID++
+	state.ID = e.workerID
+	worker := &diskIOWorker{state: state, nbReads: &atomic.Int64{}, nbWrites: &atomic.Int64{}}
+	e.ioWorkers[state.ID] = worker
+	e.lock.Unlock()
+
+	// I/O worker
+	e.workers.Add(1)
+	go func() {
+		defer e.workers.Done()
+		defer func() {
+			e.lock.Lock()
+			delete(e.ioWorkers, state.ID)
+			e.lock.Unlock()
+		}()
+		l := log.WithField("disk_io_worker_id", state.ID)
+
+		// working file, written before being used
+		file, err := os.CreateTemp(e.folder, diskIOFilePrefix+"*")
+		if err != nil {
+			l.WithError(err).Error("failed to create working file, stopping the I/O worker")
+			return
+		}
+		defer os.Remove(file.Name())
+		defer file.Close()
+		for written := int64(0); written < state.FileSize; {
+			n, err := file.Write(e.data[:min(int64(len(e.data)), state.FileSize-written)])
+			written += int64(n)
+			if err != nil {
+				l.WithError(err).Error("failed to write working file, stopping the I/O worker")
+				return
+			}
+		}
+
+		// worker loop
+		var deadline <-chan time.Time
+		if timeout > 0 {
+			deadline = time.After(timeout)
+		}
+		var interval time.Duration
+		if state.IOPS > 0 {
+			interval = time.Second / time.Duration(state.IOPS)
+		}
+		nbBlocks := state.FileSize / int64(state.BlockSize)
+		block := make([]byte, state.BlockSize)
+		next := time.Now()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-deadline:
+				l.Info("timeout reached, stopping the I/O worker")
+				return
+			default:
+			}
+
+			// operation on a random block
+			offset := rand.Int64N(nbBlocks) * int64(state.BlockSize)
+			write := state.Mode == diskIOModeWrite || (state.Mode == diskIOModeMixed && rand.IntN(2) == 0)
+			if write {
+				start := rand.IntN(len(e.data) - state.BlockSize + 1)
+				if _, err = file.WriteAt(e.data[start:start+state.BlockSize], offset); err == nil && state.Fsync {
+					err = file.Sync()
+				}
+				worker.nbWrites.Add(1)
+			} else {
+				_, err = file.ReadAt(block, offset)
+				worker.nbReads.Add(1)
+			}
+			if err != nil {
+				l.WithError(err).Error("I/O operation failed, stopping the I/O worker")
+				return
+			}
+
+			// pacing
+			if interval > 0 {
+				next = next.Add(interval)
+				if wait := time.Until(next); wait > 0 {
+					select {
+					case <-stopCh:
+						return
+					case <-time.After(wait):
+					}
+				}
+			}
+		}
+	}()
+}
+
+func (e *DiskEndpoints) Reset(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	// stopping workers
+	e.lock.Lock()
+	for _, stopFunc := range e.stopFuncs {
+		stopFunc()
+	}
+	e.stopFuncs = []func(){}
+	e.leakers = nil
+	e.lock.Unlock()
+	e.workers.Wait()
+
+	// deleting the files written by the server, the folder may be shared
+	entries, err := os.ReadDir(e.folder)
+	if err == nil {
+		for _, entry := range entries {
+			if !isDiskFile(entry) {
+				continue
+			}
+			if err = os.Remove(filepath.Join(e.folder, entry.Name())); err != nil {
+				break
+			}
+		}
+	}
+	diskStats := e.diskStats()
+	if err != nil {
+		errorString := "failed to delete files"
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(errorString + ": " + err.Error() + "\n" + diskStats))
+		l.WithError(err).Error(errorString)
+		return
+	}
+	l.Info(diskStats)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(diskStats))
+}
+
+func (e *DiskEndpoints) Status(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	diskStats := e.diskStats()
+	l.Info(diskStats)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(diskStats))
+}
+
+func (e *DiskEndpoints) State() DiskState {
+	state := DiskState{Folder: e.folder}
+	entries, _ := os.ReadDir(e.folder)
+	for _, entry := range entries {
+		if !isDiskFile(entry) {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			state.Used += info.Size()
+			state.NbFiles++
+		}
+	}
+	state.UsedHuman = SizeToHumanReadable(float64(state.Used))
+	state.Filesystem, _ = diskFilesystemState(e.folder)
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	state.LeakWorkers = append([]DiskLeakWorkerState{}, e.leakers...)
+	state.IOWorkers = []DiskIOWorkerState{}
+	for id := 1; id <= e.workerID; id++ { // ordered by ID
+		if worker, found := e.ioWorkers[id]; found {
+			workerState := worker.state
+			workerState.NbReads = worker.nbReads.Load()
+			workerState.NbWrites = worker.nbWrites.Load()
+			state.IOWorkers = append(state.IOWorkers, workerState)
+		}
+	}
+	return state
+}
+
+// isDiskFile tells whether the entry is a file written by the disk endpoints
+// (including the benchmark files left by a crash).
+func isDiskFile(entry fs.DirEntry) bool {
+	if !entry.Type().IsRegular() {
+		return false
+	}
+	for _, prefix := range []string{diskFillFilePrefix, diskLeakFilePrefix, diskIOFilePrefix, diskBenchmarkFilePrefix} {
+		if strings.HasPrefix(entry.Name(), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeFile writes a new file of the given size, synced to the disk, and
+// returns the number of bytes written.
+func (e *DiskEndpoints) writeFile(prefix string, size int64) (written int64, err error) {
+	file, err := os.CreateTemp(e.folder, prefix+"*")
+	if err != nil {
+		return 0, errors.WithMessage(err, "failed to create file")
+	}
+	defer file.Close()
+	for written < size {
+		n, err := file.Write(e.data[:min(int64(len(e.data)), size-written)])
+		written += int64(n)
+		if err != nil {
+			return written, errors.WithMessage(err, "failed to write file")
+		}
+	}
+	if err = file.Sync(); err != nil {
+		return written, errors.WithMessage(err, "failed to sync file")
+	}
+	return
+}
+
+func (e *DiskEndpoints) diskStats() string {
+	state := e.State()
+	var stats strings.Builder
+	fmt.Fprintf(&stats, "disk status: Used: %s in %d file(s), Filesystem: %s used out of %s (%.2f%%), %s available", state.UsedHuman, state.NbFiles, SizeToHumanReadable(float64(state.Filesystem.Used)), SizeToHumanReadable(float64(state.Filesystem.Size)), state.Filesystem.UsedPercent, SizeToHumanReadable(float64(state.Filesystem.Available)))
+	if len(state.LeakWorkers) > 0 {
+		fmt.Fprintf(&stats, ", %d leak worker(s)", len(state.LeakWorkers))
+	}
+	if len(state.IOWorkers) > 0 {
+		var nbReads, nbWrites int64
+		for _, worker := range state.IOWorkers {
+			nbReads += worker.NbReads
+			nbWrites += worker.NbWrites
+		}
+		fmt.Fprintf(&stats, ", %d I/O worker(s) (%d reads, %d writes)", len(state.IOWorkers), nbReads, nbWrites)
+	}
+	return stats.String()
+}
+
+// parseSize parses a positive size, in bytes.
+func parseSize(sizeString string) (int64, error) {
+	if !positiveIntegerRegex.MatchString(sizeString) {
+		return 0, errors.New("size doesn't match regex: " + positiveIntegerRegex.String())
+	}
+	size, err := strconv.ParseInt(sizeString, 10, 64)
+	if err != nil {
+		return 0, errors.WithMessage(err, "size is incorrect")
+	}
+	return size, nil
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// params
+	diskQueryParamFolder string = "folder"
+	diskQueryParamTest   string = "test"
+	diskQueryParamDirect string = "direct"
+
+	// benchmark tests, in the order they are run
+	diskBenchmarkSequentialWrite string = "seq_write"
+	diskBenchmarkSequentialRead  string = "seq_read"
+	diskBenchmarkRandomWrite     string = "rand_write"
+	diskBenchmarkRandomRead      string = "rand_read"
+
+	diskBenchmarkFilePrefix      string        = "benchmark-"
+	diskBenchmarkDefaultDuration time.Duration = 5 * time.Second
+	diskBenchmarkMaxDuration     time.Duration = 5 * time.Minute
+	diskBenchmarkMaxFileSize     int64         = 8 * 1024 * 1024 * 1024 // 8GiB
+	diskBenchmarkMaxSamples      int           = 1_000_000              // latencies kept to compute the percentiles
+	diskBenchmarkAlignment       int           = 4096                   // direct I/O buffers, offsets and sizes alignment
+)
+
+var diskBenchmarkTests = []string{diskBenchmarkSequentialWrite, diskBenchmarkSequentialRead, diskBenchmarkRandomWrite, diskBenchmarkRandomRead}
+
+// DiskBenchmarkReport describes the results of a storage benchmark.
+type DiskBenchmarkReport struct {
+	Folder    string                `json:"folder"`
+	BlockSize int                   `json:"block_size"`
+	FileSize  int64                 `json:"file_size"`
+	Direct    bool                  `json:"direct"`
+	Fsync     bool                  `json:"fsync"`
+	Duration  string                `json:"duration"` // of each test
+	Results   []DiskBenchmarkResult `json:"results"`
+}
+
+type DiskBenchmarkResult struct {
+	Test            string               `json:"test"`
+	NbOperations    int                  `json:"nb_operations"`
+	Size            int64                `json:"size"`
+	Duration        string               `json:"duration"`
+	Throughput      float64              `json:"throughput"` // in bytes per second
+	ThroughputHuman string               `json:"throughput_human"`
+	IOPS            float64              `json:"iops"`
+	Latency         DiskBenchmarkLatency `json:"latency"`
+}
+
+// DiskBenchmarkLatency is the distribution of the operations latency.
+type DiskBenchmarkLatency struct {
+	Min  string `json:"min"`
+	Mean string `json:"mean"`
+	P50  string `json:"p50"`
+	P90  string `json:"p90"`
+	P99  string `json:"p99"`
+	P999 string `json:"p99.9"`
+	Max  string `json:"max"`
+}
+
+// diskBenchmark holds the parameters of a benchmark.
+type diskBenchmark struct {
+	folder    string
+	tests     []string
+	blockSize int
+	fileSize  int64
+	direct    bool
+	fsync     bool
+	duration  time.Duration
+}
+
+func (e *DiskEndpoints) Benchmark(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	l.Debug("parsing query variables")
+	benchmark, err := e.parseBenchmark(r)
+	if err != nil {
+		errorString := err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return
+	}
+	format, ok := parseFormat(l, w, r.URL.Query())
+	if !ok {
+		return
+	}
+
+	// running the benchmark
+	l.Infof("starting disk benchmark of %s (tests: %s, block size: %d, file size: %s, direct: %t, fsync: %t), for %s per test", benchmark.folder, strings.Join(benchmark.tests, ", "), benchmark.blockSize, SizeToHumanReadable(float64(benchmark.fileSize)), benchmark.direct, benchmark.fsync, benchmark.duration)
+	report, err := benchmark.run(r.Context(), e.data)
+	if err != nil {
+		errorString := "disk benchmark failed"
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Error(errorString)
+		return
+	}
+	l.Info("disk benchmark done")
+
+	if format == echoFormatJSON {
+		writeJSON(l, w, report)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(report.String()))
+}
+
+func (e *DiskEndpoints) parseBenchmark(r *http.Request) (*diskBenchmark, error) {
+	query := r.URL.Query()
+	benchmark := &diskBenchmark{
+		folder:    e.folder,
+		tests:     diskBenchmarkTests,
+		blockSize: diskDefaultBlockSize,
+		fileSize:  diskDefaultFileSize,
+		duration:  diskBenchmarkDefaultDuration,
+	}
+	// folder
+	if folder := query.Get(diskQueryParamFolder); len(folder) > 0 {
+		if !filepath.IsAbs(folder) {
+			return nil, errors.New("folder must be an absolute path: " + folder)
+		}
+		if info, err := os.Stat(folder); err != nil {
+			return nil, errors.WithMessage(err, "folder is incorrect")
+		} else if !info.IsDir() {
+			return nil, errors.New("folder is not a directory: " + folder)
+		}
+		benchmark.folder = folder
+	}
+	// tests
+	if tests := query[diskQueryParamTest]; len(tests) > 0 {
+		benchmark.tests = nil
+		for _, test := range diskBenchmarkTests { // keeping the run order
+			if slices.Contains(tests, test) {
+				benchmark.tests = append(benchmark.tests, test)
+			}
+		}
+		for _, test := range tests {
+			if !slices.Contains(diskBenchmarkTests, test) {
+				return nil, errors.Errorf("unknown test: %q, must be one of: %s", test, strings.Join(diskBenchmarkTests, ", "))
+			}
+		}
+	}
+	// block size
+	if blockSizeString := query.Get(diskQueryParamBlockSize); len(blockSizeString) > 0 {
+		if !positiveIntegerRegex.MatchString(blockSizeString) {
+			return nil, errors.New("block size doesn't match regex: " + positiveIntegerRegex.String())
+		}
+		var err error
+		if benchmark.blockSize, err = strconv.Atoi(blockSizeString); err != nil || benchmark.blockSize < 1 || benchmark.blockSize > diskDataSize {
+			return nil, errors.Errorf("block size must be between 1 and %d", diskDataSize)
+		}
+	}
+	// file size
+	if fileSizeString := query.Get(diskQueryParamFileSize); len(fileSizeString) > 0 {
+		var err error
+		if benchmark.fileSize, err = parseSize(fileSizeString); err != nil {
+			return nil, errors.WithMessage(err, "file")
+		}
+		if benchmark.fileSize > diskBenchmarkMaxFileSize {
+			return nil, errors.Errorf("file size is superior to %d (%s)", diskBenchmarkMaxFileSize, SizeToHumanReadable(float64(diskBenchmarkMaxFileSize)))
+		}
+	}
+	if benchmark.fileSize < int64(benchmark.blockSize) {
+		return nil, errors.Errorf("file size (%d) is inferior to the block size (%d)", benchmark.fileSize, benchmark.blockSize)
+	}
+	// direct
+	if directString := query.Get(diskQueryParamDirect); len(directString) > 0 {
+		var err error
+		if benchmark.direct, err = strconv.ParseBool(directString); err != nil {
+			return nil, errors.WithMessage(err, "direct is incorrect")
+		}
+		if benchmark.direct && diskDirectFlag == 0 {
+			return nil, errors.New("direct I/O is not supported on this platform")
+		}
+		if benchmark.direct && benchmark.blockSize%diskBenchmarkAlignment != 0 {
+			return nil, errors.Errorf("block size must be a multiple of %d with direct I/O (value: %d)", diskBenchmarkAlignment, benchmark.blockSize)
+		}
+	}
+	// fsync
+	if fsyncString := query.Get(diskQueryParamFsync); len(fsyncString) > 0 {
+		var err error
+		if benchmark.fsync, err = strconv.ParseBool(fsyncString); err != nil {
+			return nil, errors.WithMessage(err, "fsync is incorrect")
+		}
+	}
+	// duration
+	if durationString := query.Get(queryParamDuration); len(durationString) > 0 {
+		var err error
+		if benchmark.duration, err = time.ParseDuration(durationString); err != nil {
+			return nil, errors.WithMessage(err, "duration is incorrect")
+		} else if benchmark.duration <= 0 {
+			return nil, errors.New("duration is inferior or equal to zero: " + benchmark.duration.String())
+		} else if benchmark.duration > diskBenchmarkMaxDuration {
+			return nil, errors.New("duration is superior to " + diskBenchmarkMaxDuration.String() + ": " + benchmark.duration.String())
+		}
+	}
+	return benchmark, nil
+}
+
+// run writes the benchmark file, then runs each test on it for the
+// benchmark duration. The file is deleted afterwards.
+func (b *diskBenchmark) run(ctx context.Context, data []byte) (report DiskBenchmarkReport, err error) {
+	report = DiskBenchmarkReport{
+		Folder:    b.folder,
+		BlockSize: b.blockSize,
+		FileSize:  b.fileSize,
+		Direct:    b.direct,
+		Fsync:     b.fsync,
+		Duration:  b.duration.String(),
+		Results:   []DiskBenchmarkResult{},
+	}
+
+	// benchmark file, written and synced before being used
+	file, err := os.CreateTemp(b.folder, diskBenchmarkFilePrefix+"*")
+	if err != nil {
+		return report, errors.WithMessage(err, "failed to create benchmark file")
+	}
+	defer os.Remove(file.Name())
+	for written := int64(0); written < b.fileSize && err == nil; {
+		var n int
+		n, err = file.Write(data[:min(int64(len(data)), b.fileSize-written)])
+		written += int64(n)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		return report, errors.WithMessage(err, "failed to write benchmark file")
+	}
+
+	// reopening the file, bypassing the page cache if asked
+	flags := os.O_RDWR
+	if b.direct {
+		flags |= diskDirectFlag
+	}
+	if file, err = os.OpenFile(file.Name(), flags, 0); err != nil {
+		return report, errors.WithMessage(err, "failed to open benchmark file")
+	}
+	defer file.Close()
+
+	buffer := alignedBuffer(b.blockSize)
+	for _, test := range b.tests {
+		result, err := b.runTest(ctx, file, test, buffer, data)
+		if err != nil {
+			return report, errors.WithMessagef(err, "%s test failed", test)
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report, nil
+}
+
+// runTest runs a single test on the benchmark file, timing each operation.
+func (b *diskBenchmark) runTest(ctx context.Context, file *os.File, test string, buffer, data []byte) (result DiskBenchmarkResult, err error) {
+	write := test == diskBenchmarkSequentialWrite || test == diskBenchmarkRandomWrite
+	random := test == diskBenchmarkRandomWrite || test == diskBenchmarkRandomRead
+	nbBlocks := b.fileSize / int64(b.blockSize)
+	latencies := diskBenchmarkLatencies{}
+
+	start := time.Now()
+	deadline := start.Add(b.duration)
+	for block := int64(0); ; block++ {
+		if block%64 == 0 { // not checking the clock and the context on every operation
+			if time.Now().After(deadline) {
+				break
+			} else if err = ctx.Err(); err != nil {
+				return
+			}
+		}
+
+		// operation on the next or a random block
+		offset := (block % nbBlocks) * int64(b.blockSize)
+		if random {
+			offset = rand.Int64N(nbBlocks) * int64(b.blockSize)
+		}
+		if write {
+			dataStart := rand.IntN(len(data) - b.blockSize + 1) // so the written blocks differ
+			copy(buffer, data[dataStart:dataStart+b.blockSize])
+		}
+		operationStart := time.Now()
+		if write {
+			if _, err = file.WriteAt(buffer, offset); err == nil && b.fsync {
+				err = file.Sync()
+			}
+		} else {
+			_, err = file.ReadAt(buffer, offset)
+		}
+		latencies.add(time.Since(operationStart))
+		if err != nil {
+			return
+		}
+	}
+	duration := time.Since(start)
+
+	// statistics
+	result = DiskBenchmarkResult{
+		Test:         test,
+		NbOperations: latencies.count,
+		Size:         int64(latencies.count) * int64(b.blockSize),
+		Duration:     duration.String(),
+	}
+	result.Throughput = float64(result.Size) / duration.Seconds()
+	result.ThroughputHuman = SizeToHumanReadable(result.Throughput) + "/s"
+	result.IOPS = float64(result.NbOperations) / duration.Seconds()
+	result.Latency = latencies.distribution()
+	return
+}
+
+// diskBenchmarkLatencies records the latencies of a test. The percentiles are
+// computed from a uniform sample (reservoir) of at most diskBenchmarkMaxSamples
+// latencies, the other statistics from all of them.
+type diskBenchmarkLatencies struct {
+	samples  []time.Duration
+	count    int
+	total    time.Duration
+	min, max time.Duration
+}
+
+func (lat *diskBenchmarkLatencies) add(latency time.Duration) {
+	lat.count++
+	lat.total += latency
+	if lat.count == 1 || latency < lat.min {
+		lat.min = latency
+	}
+	lat.max = max(lat.max, latency)
+	if len(lat.samples) < diskBenchmarkMaxSamples {
+		lat.samples = append(lat.samples, latency)
+	} else if i := rand.IntN(lat.count); i < diskBenchmarkMaxSamples {
+		lat.samples[i] = latency
+	}
+}
+
+func (lat *diskBenchmarkLatencies) distribution() (latency DiskBenchmarkLatency) {
+	if lat.count == 0 {
+		return
+	}
+	slices.Sort(lat.samples)
+	percentile := func(p float64) string {
+		index := int(math.Ceil(p/100*float64(len(lat.samples)))) - 1
+		return lat.samples[max(index, 0)].String()
+	}
+	return DiskBenchmarkLatency{
+		Min:  lat.min.String(),
+		Mean: (lat.total / time.Duration(lat.count)).String(),
+		P50:  percentile(50),
+		P90:  percentile(90),
+		P99:  percentile(99),
+		P999: percentile(99.9),
+		Max:  lat.max.String(),
+	}
+}
+
+func (report DiskBenchmarkReport) String() string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "disk benchmark of %s (block size: %d, file size: %s, direct: %t, fsync: %t), %s per test\n", report.Folder, report.BlockSize, SizeToHumanReadable(float64(report.FileSize)), report.Direct, report.Fsync, report.Duration)
+	for _, result := range report.Results {
+		fmt.Fprintf(&text, "%s: %.0f IOPS, %s, %d operations in %s, latency: min %s, mean %s, p50 %s, p90 %s, p99 %s, p99.9 %s, max %s\n", result.Test, result.IOPS, result.ThroughputHuman, result.NbOperations, result.Duration, result.Latency.Min, result.Latency.Mean, result.Latency.P50, result.Latency.P90, result.Latency.P99, result.Latency.P999, result.Latency.Max)
+	}
+	return text.String()
+}
+
+// alignedBuffer returns a buffer of the given size, aligned in memory as
+// required by direct I/O.
+func alignedBuffer(size int) []byte {
+	buffer := make([]byte, size+diskBenchmarkAlignment)
+	shift := 0
+	if remainder := int(uintptr(unsafe.Pointer(&buffer[0])) % uintptr(diskBenchmarkAlignment)); remainder > 0 {
+		shift = diskBenchmarkAlignment - remainder
+	}
+	return buffer[shift : shift+size]
+}
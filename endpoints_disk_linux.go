@@ -0,0 +1,6 @@
+package main
+
+import "syscall"
+
+// diskDirectFlag opens files bypassing the page cache.
+const diskDirectFlag = syscall.O_DIRECT
@@ -0,0 +1,6 @@
+//go:build !linux
+
+package main
+
+// diskDirectFlag is not available on this platform, direct I/O is refused.
+const diskDirectFlag = 0
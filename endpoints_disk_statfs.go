@@ -0,0 +1,22 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package main
+
+import "syscall"
+
+// diskFilesystemState returns the usage of the filesystem of the folder.
+func diskFilesystemState(folder string) (state DiskFilesystemState, err error) {
+	var stat syscall.Statfs_t
+	if err = syscall.Statfs(folder, &stat); err != nil {
+		return
+	}
+	// the fields types differ between platforms
+	blockSize := uint64(stat.Bsize)
+	state.Size = uint64(stat.Blocks) * blockSize
+	state.Used = uint64(stat.Blocks-stat.Bfree) * blockSize
+	state.Available = uint64(max(stat.Bavail, 0)) * blockSize // negative on BSDs when the reserved blocks are used
+	if state.Used+state.Available > 0 {
+		state.UsedPercent = float64(state.Used) / float64(state.Used+state.Available) * 100
+	}
+	return
+}
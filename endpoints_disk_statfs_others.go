@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd || dragonfly)
+
+package main
+
+import "github.com/pkg/errors"
+
+// diskFilesystemState is not available on this platform, the filesystem
+// usage is reported as empty.
+func diskFilesystemState(folder string) (state DiskFilesystemState, err error) {
+	return state, errors.New("filesystem usage is not supported on this platform")
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// download contents
+	downloadContentZeros  string = "zeros"
+	downloadContentRandom string = "random"
+	downloadContentSeeded string = "seeded"
+	downloadContentText   string = "text"
+
+	// splitmix64 increment
+	splitmix64Gamma uint64 = 0x9e3779b97f4a7c15
+)
+
+func download(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	l.Debug("parsing query variables")
+	query := r.URL.Query()
+	// size
+	size := int64(size1MiB)
+	sizeString := query.Get(queryParamSize)
+	if len(sizeString) > 0 {
+		if !positiveIntegerRegex.MatchString(sizeString) {
+			errorString := "size doesn't match regex: " + positiveIntegerRegex.String()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+		var err error
+		if size, err = strconv.ParseInt(sizeString, 10, 64); err != nil {
+			errorString := "size is incorrect: " + err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+	}
+	// content
+	content, err := newDownloadContent(size, query.Get(queryParamContent), query.Get(queryParamSeed), query.Get(queryParamText))
+	if err != nil {
+		errorString := err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return
+	}
+	// throttle
+	throttle, err := ParseThrottle(query)
+	if err != nil {
+		errorString := err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return
+	}
+	// content type
+	contentType := query.Get(queryParamContentType)
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+		if content.mode == downloadContentText {
+			contentType = "text/plain; charset=utf-8"
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	// file name
+	if filename := query.Get(queryParamFilename); len(filename) > 0 {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	}
+
+	// random content changes on every request, it can't be identified
+	if content.mode != downloadContentRandom {
+		w.Header().Set("ETag", content.etag)
+	} else {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+
+	if err = throttle.Wait(r.Context()); err != nil {
+		l.WithError(err).Warn("download canceled during the initial delay")
+		return
+	}
+	l.Infof("starting download of %s (%d Bytes) of %s content, bandwidth: %s", SizeToHumanReadable(float64(size)), size, content.mode, throttle)
+	defer l.Info("download finished")
+	http.ServeContent(throttle.ResponseWriter(r.Context(), w), r, "", time.Time{}, content) // handles range and conditional requests
+}
+
+// downloadContent generates the content to download on the fly, it can be
+// read from any offset.
+type downloadContent struct {
+	mode   string
+	etag   string
+	size   int64
+	offset int64
+	fill   func(p []byte, offset int64) // fills p with the content at offset
+}
+
+func newDownloadContent(size int64, mode, seedString, text string) (*downloadContent, error) {
+	if len(mode) == 0 {
+		mode = downloadContentZeros
+	}
+	c := &downloadContent{mode: mode, size: size}
+
+	// the entity tag identifies the generated content
+	etag := sha256.New()
+	fmt.Fprintf(etag, "%s\n%d\n", mode, size)
+	switch mode {
+	case downloadContentZeros:
+		c.fill = func(p []byte, offset int64) {
+			clear(p)
+		}
+	case downloadContentRandom:
+		var seed [32]byte
+		cryptorand.Read(seed[:])
+		generator := rand.NewChaCha8(seed)
+		c.fill = func(p []byte, offset int64) {
+			generator.Read(p)
+		}
+	case downloadContentSeeded:
+		var seed uint64
+		if len(seedString) > 0 {
+			var err error
+			if seed, err = strconv.ParseUint(seedString, 10, 64); err != nil {
+				return nil, errors.WithMessage(err, "seed is incorrect")
+			}
+		}
+		fmt.Fprintf(etag, "%d\n", seed)
+		c.fill = func(p []byte, offset int64) {
+			fillSeeded(p, offset, seed)
+		}
+	case downloadContentText:
+		if len(text) == 0 {
+			return nil, errors.Errorf("text must be set with the %s content", downloadContentText)
+		}
+		etag.Write([]byte(text))
+		c.fill = func(p []byte, offset int64) {
+			for i := range p {
+				p[i] = text[(offset+int64(i))%int64(len(text))]
+			}
+		}
+	default:
+		return nil, errors.Errorf("unknown content: %q, must be one of: %s, %s, %s, %s", mode, downloadContentZeros, downloadContentRandom, downloadContentSeeded, downloadContentText)
+	}
+	c.etag = `"` + hex.EncodeToString(etag.Sum(nil)[:16]) + `"`
+	return c, nil
+}
+
+func (c *downloadContent) Read(p []byte) (int, error) {
+	if c.offset >= c.size {
+		return 0, io.EOF
+	}
+	p = p[:min(int64(len(p)), c.size-c.offset)]
+	c.fill(p, c.offset)
+	c.offset += int64(len(p))
+	return len(p), nil
+}
+
+func (c *downloadContent) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += c.offset
+	case io.SeekEnd:
+		offset += c.size
+	default:
+		return 0, errors.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	c.offset = offset
+	return offset, nil
+}
+
+// fillSeeded fills p with the seeded content at offset: the splitmix64 stream
+// of the seed, each value written as 8 little endian bytes. Any part of the
+// content can be generated without generating what precedes it.
+func fillSeeded(p []byte, offset int64, seed uint64) {
+	var word [8]byte
+	for i := range p {
+		position := offset + int64(i)
+		if i == 0 || position%8 == 0 {
+			binary.LittleEndian.PutUint64(word[:], splitmix64(seed+uint64(position/8+1)*splitmix64Gamma))
+		}
+		p[i] = word[position%8]
+	}
+}
+
+func splitmix64(z uint64) uint64 {
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// headers order
+	echoHeadersOrderReceived string = "received"
+	echoHeadersOrderSorted   string = "sorted"
+)
+
+// EchoRequest is the structured description of a received request.
+type EchoRequest struct {
+	Method                      string              `json:"method"`
+	URL                         string              `json:"url"`
+	Proto                       string              `json:"proto"`
+	Host                        string              `json:"host"`
+	RemoteAddr                  string              `json:"remote_addr"`
+	Query                       map[string][]string `json:"query"`
+	Headers                     []RawHeader         `json:"headers"`
+	HeadersOrder                string              `json:"headers_order"`
+	HeadersOrderInfo            string              `json:"headers_order_info,omitempty"`             // why the received order is not available
+	RawHeadersUnavailableReason string              `json:"raw_headers_unavailable_reason,omitempty"` // one of the rawHeadersUnavailable constants
+	Cookies                     []HARCookie         `json:"cookies"`
+	ContentLength               int64               `json:"content_length"`
+	TransferEncoding            []string            `json:"transfer_encoding"`
+	Body                        EchoBody            `json:"body"`
+	TLS                         *EchoTLS            `json:"tls,omitempty"`
+}
+
+// EchoBody is the request body, as received (raw if valid UTF-8, base64
+// encoded otherwise) and decoded according to its content type.
+type EchoBody struct {
+	Size   int                 `json:"size"`
+	Raw    string              `json:"raw,omitempty"`
+	Base64 string              `json:"base64,omitempty"`
+	JSON   any                 `json:"json,omitempty"`
+	Form   map[string][]string `json:"form,omitempty"`
+	Error  string              `json:"error,omitempty"`
+}
+
+type EchoTLS struct {
+	Version            string `json:"version"`
+	CipherSuite        string `json:"cipher_suite"`
+	ServerName         string `json:"server_name"`
+	NegotiatedProtocol string `json:"negotiated_protocol"`
+}
+
+// NewEchoRequest describes the request, reading its whole body.
+func NewEchoRequest(l *log.Entry, r *http.Request) (e EchoRequest, err error) {
+	e = EchoRequest{
+		Method:           r.Method,
+		URL:              requestFullURL(r),
+		Proto:            r.Proto,
+		Host:             r.Host,
+		RemoteAddr:       r.RemoteAddr,
+		Query:            r.URL.Query(),
+		Cookies:          harCookies(r.Cookies()),
+		ContentLength:    r.ContentLength,
+		TransferEncoding: r.TransferEncoding,
+	}
+	if e.TransferEncoding == nil {
+		e.TransferEncoding = []string{}
+	}
+
+	// headers
+	if e.Headers, e.RawHeadersUnavailableReason, err = rawRequestHeaders(r); err == nil {
+		e.HeadersOrder = echoHeadersOrderReceived
+	} else {
+		l.WithError(err).Debug("raw headers not available, sorting them")
+		e.HeadersOrder, e.HeadersOrderInfo, err = echoHeadersOrderSorted, err.Error(), nil
+		e.Headers = []RawHeader{{Name: "Host", Value: r.Host}}
+		names := make([]string, 0, len(r.Header))
+		for name := range r.Header {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, value := range r.Header[name] {
+				e.Headers = append(e.Headers, RawHeader{Name: name, Value: value})
+			}
+		}
+	}
+
+	// body
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+	e.Body = newEchoBody(r, body)
+
+	// tls
+	if r.TLS != nil {
+		e.TLS = &EchoTLS{
+			Version:            tls.VersionName(r.TLS.Version),
+			CipherSuite:        tls.CipherSuiteName(r.TLS.CipherSuite),
+			ServerName:         r.TLS.ServerName,
+			NegotiatedProtocol: r.TLS.NegotiatedProtocol,
+		}
+	}
+	return
+}
+
+func newEchoBody(r *http.Request, body []byte) (b EchoBody) {
+	b.Size = len(body)
+	if len(body) == 0 {
+		return
+	}
+	if utf8.Valid(body) {
+		b.Raw = string(body)
+	} else {
+		b.Base64 = base64.StdEncoding.EncodeToString(body)
+	}
+
+	// decoding
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		if err := json.Unmarshal(body, &b.JSON); err != nil {
+			b.Error = "failed to parse JSON body: " + err.Error()
+		}
+	case mediaType == "application/x-www-form-urlencoded":
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			b.Error = "failed to parse form body: " + err.Error()
+		}
+		b.Form = form
+	case mediaType == "multipart/form-data":
+		// parsing a copy of the request with the body already read
+		formRequest := r.Clone(r.Context())
+		formRequest.Body = io.NopCloser(bytes.NewReader(body))
+		if err := formRequest.ParseMultipartForm(MaxFormSize); err != nil {
+			b.Error = "failed to parse multipart form body: " + err.Error()
+			return
+		}
+		defer formRequest.MultipartForm.RemoveAll()
+		b.Form = formRequest.MultipartForm.Value
+	}
+	return
+}
+
+// requestFullURL rebuilds the URL requested by the client.
+func requestFullURL(r *http.Request) string {
+	u := url.URL{
+		Scheme:   "http",
+		Host:     r.Host,
+		Path:     r.URL.Path,
+		RawPath:  r.URL.RawPath,
+		RawQuery: r.URL.RawQuery,
+	}
+	if r.TLS != nil {
+		u.Scheme = "https"
+	}
+	return u.String()
+}
+
+// FormFile describes a file uploaded in a multipart form.
+type FormFile struct {
+	Field              string `json:"field"`
+	Filename           string `json:"filename"`
+	ContentType        string `json:"content_type"`
+	SniffedContentType string `json:"sniffed_content_type"`
+	Size               int64  `json:"size"`
+	SHA256             string `json:"sha256"`
+}
+
+// NewFormFiles describes all the files of the form, ordered by field name.
+func NewFormFiles(form *multipart.Form) ([]FormFile, error) {
+	fields := make([]string, 0, len(form.File))
+	for field := range form.File {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	files := []FormFile{}
+	for _, field := range fields {
+		for _, fileHeader := range form.File[field] {
+			file, err := NewFormFile(field, fileHeader)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// NewFormFile describes an uploaded file, reading its content to sniff its
+// type and compute its checksum.
+func NewFormFile(field string, fileHeader *multipart.FileHeader) (f FormFile, err error) {
+	f = FormFile{
+		Field:       field,
+		Filename:    fileHeader.Filename,
+		ContentType: fileHeader.Header.Get("Content-Type"),
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return f, errors.WithMessagef(err, "failed to open file %q", fileHeader.Filename)
+	}
+	defer file.Close()
+
+	// sniffing (only the first 512 bytes are considered)
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return f, errors.WithMessagef(err, "failed to read file %q", fileHeader.Filename)
+	}
+	f.SniffedContentType = http.DetectContentType(head[:n])
+
+	// checksum
+	hash := sha256.New()
+	hash.Write(head[:n])
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return f, errors.WithMessagef(err, "failed to read file %q", fileHeader.Filename)
+	}
+	f.Size = int64(n) + size
+	f.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return
+}
+
+func (f FormFile) String() string {
+	return fmt.Sprintf("%s: %s (type: %s, sniffed type: %s, size: %d Bytes, sha256: %s)", f.Field, f.Filename, f.ContentType, f.SniffedContentType, f.Size, f.SHA256)
+}
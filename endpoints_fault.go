@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// params
+	faultQueryParamContentLength string = "content_length"
+	faultQueryParamStatusLine    string = "status_line"
+
+	// defaults
+	faultDefaultContentLength int64         = 1024
+	faultDefaultStatusLine    string        = "HTTP/1.1 2OO MALFORMED"
+	faultDefaultDuration      time.Duration = 10 * time.Second
+
+	faultBodyText string = "integration tester webserver\n"
+)
+
+// hijackedConn is a connection taken over from the HTTP server, on which
+// responses are written by hand.
+type hijackedConn struct {
+	net.Conn
+	buffer *bufio.ReadWriter
+	l      *log.Entry
+}
+
+// faultRST resets the TCP connection before sending any response.
+func faultRST(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	delay, ok := parseFaultDuration(l, w, r.URL.Query(), queryParamDelay, 0)
+	if !ok {
+		return
+	}
+	conn, ok := hijack(l, w, r, delay)
+	if !ok {
+		return
+	}
+
+	tcpConn := conn.tcpConn()
+	if tcpConn == nil {
+		l.Warnf("connection of type %T can't be reset, closing it", conn.Conn)
+		conn.Close()
+		return
+	}
+	l.Info("resetting connection")
+	tcpConn.SetLinger(0) // the connection is reset when closed
+	tcpConn.Close()      // without TLS close notify
+}
+
+// faultCloseAfterHeaders closes the connection right after sending the
+// response headers.
+func faultCloseAfterHeaders(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	contentLength, ok := parseFaultSize(l, w, query, faultQueryParamContentLength, faultDefaultContentLength)
+	if !ok {
+		return
+	}
+	delay, ok := parseFaultDuration(l, w, query, queryParamDelay, 0)
+	if !ok {
+		return
+	}
+	conn, ok := hijack(l, w, r, delay)
+	if !ok {
+		return
+	}
+	defer conn.Close()
+
+	l.Infof("sending headers (content length: %d), then closing connection", contentLength)
+	conn.writeHeaders("HTTP/1.1 200 OK", http.Header{"Content-Length": {strconv.FormatInt(contentLength, 10)}})
+	conn.flush()
+}
+
+// faultTruncatedBody sends a body shorter than the announced
+// Content-Length, then closes the connection.
+func faultTruncatedBody(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	contentLength, ok := parseFaultSize(l, w, query, faultQueryParamContentLength, faultDefaultContentLength)
+	if !ok {
+		return
+	}
+	size, ok := parseFaultSize(l, w, query, queryParamSize, contentLength/2)
+	if !ok {
+		return
+	}
+	if size >= contentLength {
+		errorString := fmt.Sprintf("size (%d) must be inferior to the content length (%d) to truncate the body", size, contentLength)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return
+	}
+	delay, ok := parseFaultDuration(l, w, query, queryParamDelay, 0)
+	if !ok {
+		return
+	}
+	conn, ok := hijack(l, w, r, delay)
+	if !ok {
+		return
+	}
+	defer conn.Close()
+
+	l.Infof("sending %d Bytes of body (content length: %d), then closing connection", size, contentLength)
+	conn.writeHeaders("HTTP/1.1 200 OK", http.Header{"Content-Length": {strconv.FormatInt(contentLength, 10)}})
+	conn.writeBody(size)
+	conn.flush()
+}
+
+// faultMalformedStatus sends a response with a malformed status line.
+func faultMalformedStatus(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	statusLine := faultDefaultStatusLine
+	if query.Has(faultQueryParamStatusLine) {
+		statusLine = query.Get(faultQueryParamStatusLine)
+	}
+	if strings.ContainsAny(statusLine, "\r\n") {
+		errorString := "status line can't contain line breaks"
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return
+	}
+	delay, ok := parseFaultDuration(l, w, query, queryParamDelay, 0)
+	if !ok {
+		return
+	}
+	conn, ok := hijack(l, w, r, delay)
+	if !ok {
+		return
+	}
+	defer conn.Close()
+
+	l.Infof("sending malformed status line: %q", statusLine)
+	conn.writeHeaders(statusLine, http.Header{"Content-Length": {strconv.Itoa(len(faultBodyText))}})
+	conn.writeBody(int64(len(faultBodyText)))
+	conn.flush()
+}
+
+// faultInvalidChunked sends a chunked body with an invalid chunk, then closes
+// the connection.
+func faultInvalidChunked(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	delay, ok := parseFaultDuration(l, w, r.URL.Query(), queryParamDelay, 0)
+	if !ok {
+		return
+	}
+	conn, ok := hijack(l, w, r, delay)
+	if !ok {
+		return
+	}
+	defer conn.Close()
+
+	l.Info("sending invalid chunked body")
+	conn.writeHeaders("HTTP/1.1 200 OK", http.Header{"Transfer-Encoding": {"chunked"}})
+	fmt.Fprintf(conn.buffer, "%x\r\n", len(faultBodyText)) // valid chunk
+	conn.writeBody(int64(len(faultBodyText)))
+	conn.buffer.WriteString("\r\nzz\r\n") // invalid chunk size
+	conn.writeBody(int64(len(faultBodyText)))
+	conn.flush()
+}
+
+// faultSlowHeaders sends the response headers byte by byte over the
+// duration, then the body.
+func faultSlowHeaders(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	duration, ok := parseFaultDuration(l, w, query, queryParamDuration, faultDefaultDuration)
+	if !ok {
+		return
+	}
+	delay, ok := parseFaultDuration(l, w, query, queryParamDelay, 0)
+	if !ok {
+		return
+	}
+	conn, ok := hijack(l, w, r, delay)
+	if !ok {
+		return
+	}
+	defer conn.Close()
+
+	// headers
+	headers := &strings.Builder{}
+	writeRawHeaders(headers, "HTTP/1.1 200 OK", http.Header{
+		"Content-Length": {strconv.Itoa(len(faultBodyText))},
+		"Content-Type":   {"text/plain; charset=utf-8"},
+	})
+	raw := headers.String()
+	l.Infof("sending %d Bytes of headers over %s", len(raw), duration)
+	interval := duration / time.Duration(len(raw))
+	for i := range len(raw) {
+		if _, err := conn.Write([]byte{raw[i]}); err != nil {
+			l.WithError(err).Warn("failed to send headers, closing connection")
+			return
+		}
+		if err := sleepContext(r.Context(), interval); err != nil {
+			l.WithError(err).Warn("client went away, closing connection")
+			return
+		}
+	}
+	conn.writeBody(int64(len(faultBodyText)))
+	conn.flush()
+}
+
+// faultHalfClose closes the write side of the connection without sending any
+// response, and keeps reading the connection until the client closes it or
+// the duration is reached.
+func faultHalfClose(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	duration, ok := parseFaultDuration(l, w, query, queryParamDuration, faultDefaultDuration)
+	if !ok {
+		return
+	}
+	delay, ok := parseFaultDuration(l, w, query, queryParamDelay, 0)
+	if !ok {
+		return
+	}
+	conn, ok := hijack(l, w, r, delay)
+	if !ok {
+		return
+	}
+	defer conn.Close()
+
+	var closeWriter interface{ CloseWrite() error }
+	if tlsConn, isTLS := conn.Conn.(*tls.Conn); isTLS {
+		closeWriter = tlsConn // sends a TLS close notify first
+	} else if tcpConn := conn.tcpConn(); tcpConn != nil {
+		closeWriter = tcpConn
+	} else {
+		l.Warnf("connection of type %T can't be half closed, closing it", conn.Conn)
+		return
+	}
+	l.Infof("closing the write side of the connection, reading for %s", duration)
+	if err := closeWriter.CloseWrite(); err != nil {
+		l.WithError(err).Warn("failed to half close connection")
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(duration))
+	read, _ := io.Copy(io.Discard, conn.buffer) // until the client closes or the deadline
+	l.Infof("closing connection, %d Bytes read after the half close", read)
+}
+
+// hijack takes over the connection, after waiting for the delay. If the
+// connection can't be hijacked (i.e.: HTTP/2), the error is written to the
+// client and ok is false.
+func hijack(l *log.Entry, w http.ResponseWriter, r *http.Request, delay time.Duration) (conn *hijackedConn, ok bool) {
+	if err := sleepContext(r.Context(), delay); err != nil {
+		l.WithError(err).Warn("request canceled during the delay")
+		return nil, false
+	}
+	netConn, buffer, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		errorString := "failed to hijack connection, only HTTP/1.x connections can be hijacked"
+		w.WriteHeader(http.StatusHTTPVersionNotSupported)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Warn(errorString)
+		return nil, false
+	}
+	return &hijackedConn{Conn: netConn, buffer: buffer, l: l}, true
+}
+
+// tcpConn returns the TCP connection underlying the connection, nil if there
+// is none.
+func (c *hijackedConn) tcpConn() *net.TCPConn {
+	conn := c.Conn
+	for {
+		switch typedConn := conn.(type) {
+		case *net.TCPConn:
+			return typedConn
+		case interface{ NetConn() net.Conn }: // TLS and recording connections
+			conn = typedConn.NetConn()
+		default:
+			return nil
+		}
+	}
+}
+
+func (c *hijackedConn) writeHeaders(statusLine string, header http.Header) {
+	header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	writeRawHeaders(c.buffer, statusLine, header)
+}
+
+// writeBody writes size bytes of body, made of the repeated body text.
+func (c *hijackedConn) writeBody(size int64) {
+	content, _ := newDownloadContent(size, downloadContentText, "", faultBodyText) // can't fail with a text
+	io.Copy(c.buffer, content)
+}
+
+func (c *hijackedConn) flush() {
+	if err := c.buffer.Flush(); err != nil {
+		c.l.WithError(err).Warn("failed to write response")
+	}
+}
+
+func writeRawHeaders(w io.Writer, statusLine string, header http.Header) {
+	io.WriteString(w, statusLine+"\r\n")
+	header.Write(w)
+	io.WriteString(w, "\r\n")
+}
+
+// parseFaultSize parses a size query parameter. If it is incorrect, the
+// error is written to the client and ok is false.
+func parseFaultSize(l *log.Entry, w http.ResponseWriter, query url.Values, name string, defaultSize int64) (size int64, ok bool) {
+	sizeString := query.Get(name)
+	if len(sizeString) == 0 {
+		return defaultSize, true
+	}
+	size, err := parseSize(sizeString)
+	if err != nil {
+		errorString := name + ": " + err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return 0, false
+	}
+	return size, true
+}
+
+// parseFaultDuration parses a duration query parameter. If it is incorrect,
+// the error is written to the client and ok is false.
+func parseFaultDuration(l *log.Entry, w http.ResponseWriter, query url.Values, name string, defaultDuration time.Duration) (duration time.Duration, ok bool) {
+	durationString := query.Get(name)
+	if len(durationString) == 0 {
+		return defaultDuration, true
+	}
+	duration, err := time.ParseDuration(durationString)
+	if err == nil && duration < 0 {
+		err = errors.New("inferior to zero: " + duration.String())
+	}
+	if err != nil {
+		errorString := name + " is incorrect: " + err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return 0, false
+	}
+	return duration, true
+}
@@ -0,0 +1,595 @@
+package main
+
+import (
+	"container/list"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// path values
+	hooksPathValueBucket string = "bucket"
+
+	// query params
+	hooksQueryParamAfter   string = "after"
+	hooksQueryParamTimeout string = "timeout"
+
+	// form data keys
+	hooksFormDataProfile   string = "profile"
+	hooksFormDataSecret    string = "secret"
+	hooksFormDataHeader    string = "header"
+	hooksFormDataAlgorithm string = "algorithm"
+	hooksFormDataEncoding  string = "encoding"
+	hooksFormDataTolerance string = "tolerance"
+
+	// signature profiles
+	hooksProfileNone    string = "none"
+	hooksProfileGitHub  string = "github"
+	hooksProfileStripe  string = "stripe"
+	hooksProfileGeneric string = "generic"
+	// signature headers
+	hooksGitHubSignatureHeader string = "X-Hub-Signature-256"
+	hooksStripeSignatureHeader string = "Stripe-Signature"
+	// signature algorithms
+	hooksAlgorithmSHA1   string = "sha1"
+	hooksAlgorithmSHA256 string = "sha256"
+	hooksAlgorithmSHA512 string = "sha512"
+	// signature encodings
+	hooksEncodingHex    string = "hex"
+	hooksEncodingBase64 string = "base64"
+
+	// defaults
+	hooksDefaultWaitTimeout     time.Duration = 30 * time.Second
+	hooksDefaultStripeTolerance time.Duration = 5 * time.Minute // same as Stripe libraries
+
+	// number of buckets kept, the least recently used are deleted so random
+	// bucket names can't fill the memory
+	hooksMaxBuckets int = 1000
+)
+
+// HookDelivery is a payload delivered to a webhook bucket.
+type HookDelivery struct {
+	ID               int64       `json:"id"`
+	Bucket           string      `json:"bucket"`
+	Date             time.Time   `json:"date"`
+	Method           string      `json:"method"`
+	URL              string      `json:"url"`
+	RemoteAddr       string      `json:"remote_addr"`
+	Headers          http.Header `json:"headers"`
+	Body             string      `json:"body"`
+	SignatureProfile string      `json:"signature_profile"`
+	SignatureValid   *bool       `json:"signature_valid,omitempty"`
+	SignatureError   string      `json:"signature_error,omitempty"`
+}
+
+// HooksEndpoints stores payloads delivered to webhook buckets, and lets
+// clients retrieve or wait for them.
+type HooksEndpoints struct {
+	lock        *sync.Mutex
+	buckets     map[string]*list.Element // by name, in the LRU list
+	lru         *list.List               // of *hookBucket, most recently used first
+	created     chan struct{}            // closed (and replaced) on each bucket creation
+	size        int
+	maxBodySize int64
+}
+
+func NewHooksEndpoints(size int, maxBodySize int64) *HooksEndpoints {
+	return &HooksEndpoints{
+		lock:        &sync.Mutex{},
+		buckets:     map[string]*list.Element{},
+		lru:         list.New(),
+		created:     make(chan struct{}),
+		size:        size,
+		maxBodySize: maxBodySize,
+	}
+}
+
+type hookBucket struct {
+	name         string
+	deliveries   []HookDelivery
+	lastID       int64
+	verification hookVerification
+	notify       chan struct{} // closed (and replaced) on each delivery, and on deletion
+}
+
+// HookBucketState describes a webhook bucket, the verification secret is
+// never exposed.
+type HookBucketState struct {
+	Name             string `json:"name"`
+	NbDeliveries     int    `json:"nb_deliveries"`
+	LastID           int64  `json:"last_id"`
+	SignatureProfile string `json:"signature_profile"`
+}
+
+// State returns the existing buckets, ordered by name.
+func (e *HooksEndpoints) State() []HookBucketState {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	state := make([]HookBucketState, 0, len(e.buckets))
+	for element := e.lru.Front(); element != nil; element = element.Next() {
+		b := element.Value.(*hookBucket)
+		state = append(state, HookBucketState{
+			Name:             b.name,
+			NbDeliveries:     len(b.deliveries),
+			LastID:           b.lastID,
+			SignatureProfile: b.verification.profile,
+		})
+	}
+	sort.Slice(state, func(i, j int) bool { return state[i].Name < state[j].Name })
+	return state
+}
+
+// bucket returns the bucket with the given name, and creates it if needed.
+// Beyond hooksMaxBuckets, the least recently used bucket is deleted. The lock
+// must be held by the caller.
+func (e *HooksEndpoints) bucket(name string) *hookBucket {
+	if element, found := e.buckets[name]; found {
+		e.lru.MoveToFront(element)
+		return element.Value.(*hookBucket)
+	}
+	b := &hookBucket{
+		name:         name,
+		verification: hookVerification{profile: hooksProfileNone},
+		notify:       make(chan struct{}),
+	}
+	e.buckets[name] = e.lru.PushFront(b)
+	if e.lru.Len() > hooksMaxBuckets {
+		e.delete(e.lru.Back())
+	}
+	// waking up waiters of the bucket
+	close(e.created)
+	e.created = make(chan struct{})
+	return b
+}
+
+// lookup returns the bucket with the given name, or nil if it doesn't exist.
+// The lock must be held by the caller.
+func (e *HooksEndpoints) lookup(name string) *hookBucket {
+	if element, found := e.buckets[name]; found {
+		return element.Value.(*hookBucket)
+	}
+	return nil
+}
+
+// delete deletes the bucket, and wakes up its waiters. The lock must be held
+// by the caller.
+func (e *HooksEndpoints) delete(element *list.Element) {
+	b := e.lru.Remove(element).(*hookBucket)
+	delete(e.buckets, b.name)
+	close(b.notify)
+}
+
+func (e *HooksEndpoints) Deliver(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue(hooksPathValueBucket)
+
+	// reading payload
+	body, err := io.ReadAll(io.LimitReader(r.Body, e.maxBodySize+1))
+	if err != nil {
+		errorString := "failed to read delivery payload"
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Warn(errorString)
+		return
+	} else if int64(len(body)) > e.maxBodySize {
+		errorString := fmt.Sprintf("delivery payload exceeds the maximum size of %s (%d Bytes)", SizeToHumanReadable(float64(e.maxBodySize)), e.maxBodySize)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	delivery := HookDelivery{
+		Bucket:     name,
+		Date:       time.Now(),
+		Method:     r.Method,
+		URL:        scheme + "://" + r.Host + r.URL.RequestURI(),
+		RemoteAddr: r.RemoteAddr,
+		Headers:    r.Header.Clone(),
+		Body:       string(body),
+	}
+
+	e.lock.Lock()
+	b := e.bucket(name)
+	// signature
+	delivery.SignatureProfile = b.verification.profile
+	if b.verification.profile != hooksProfileNone {
+		valid := true
+		if err = b.verification.Verify(r.Header, body, delivery.Date); err != nil {
+			valid = false
+			delivery.SignatureError = err.Error()
+		}
+		delivery.SignatureValid = &valid
+	}
+	// storing
+	b.lastID++
+	delivery.ID = b.lastID
+	b.deliveries = append(b.deliveries, delivery)
+	if len(b.deliveries) > e.size {
+		b.deliveries = b.deliveries[len(b.deliveries)-e.size:]
+	}
+	// waking up waiters
+	close(b.notify)
+	b.notify = make(chan struct{})
+	e.lock.Unlock()
+
+	answer := fmt.Sprintf("delivery #%d stored in bucket %s (%d Bytes)", delivery.ID, name, len(body))
+	if delivery.SignatureValid != nil {
+		if *delivery.SignatureValid {
+			answer += fmt.Sprintf(", %s signature is valid", delivery.SignatureProfile)
+		} else {
+			answer += fmt.Sprintf(", %s signature is invalid: %s", delivery.SignatureProfile, delivery.SignatureError)
+		}
+	}
+	l.Info(answer)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(answer))
+}
+
+func (e *HooksEndpoints) Deliveries(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue(hooksPathValueBucket)
+	format, ok := parseFormat(l, w, r.URL.Query())
+	if !ok {
+		return // error already sent
+	}
+
+	e.lock.Lock()
+	var deliveries []HookDelivery
+	if b := e.lookup(name); b != nil {
+		deliveries = append(deliveries, b.deliveries...)
+	}
+	e.lock.Unlock()
+
+	if format == echoFormatJSON {
+		if deliveries == nil {
+			deliveries = []HookDelivery{}
+		}
+		writeJSON(l, w, deliveries)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if len(deliveries) == 0 {
+		w.Write([]byte(">>>>> NO DELIVERY <<<<<"))
+	}
+	for _, delivery := range deliveries {
+		writeHookDelivery(w, delivery)
+	}
+}
+
+// Wait waits for the next delivery of the bucket (or the first one after the
+// given identifier), until the timeout is reached.
+func (e *HooksEndpoints) Wait(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue(hooksPathValueBucket)
+
+	l.Debug("parsing query variables")
+	format, ok := parseFormat(l, w, r.URL.Query())
+	if !ok {
+		return // error already sent
+	}
+	// timeout
+	timeout := hooksDefaultWaitTimeout
+	var err error
+	if timeoutString := r.URL.Query().Get(hooksQueryParamTimeout); len(timeoutString) > 0 {
+		if timeout, err = time.ParseDuration(timeoutString); err != nil {
+			errorString := "timeout is incorrect: " + err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		} else if timeout < 0 {
+			errorString := "timeout is inferior to zero: " + timeout.String()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+	}
+	// after
+	var after int64 // defaults to the next delivery
+	e.lock.Lock()
+	waited := e.lookup(name)
+	if waited != nil {
+		after = waited.lastID
+	}
+	e.lock.Unlock()
+	if afterString := r.URL.Query().Get(hooksQueryParamAfter); len(afterString) > 0 {
+		if !positiveIntegerRegex.MatchString(afterString) {
+			errorString := "after doesn't match regex: " + positiveIntegerRegex.String()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+		after, _ = strconv.ParseInt(afterString, 10, 64) // can't fail thanks to the regexp
+	}
+
+	// waiting
+	l.Infof("waiting up to %s for a delivery after #%d in bucket %s", timeout.String(), after, name)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		e.lock.Lock()
+		notify := e.created // the bucket doesn't exist yet
+		if b := e.lookup(name); b != nil {
+			if waited != nil && b != waited {
+				after = 0 // the bucket has been deleted and created again, identifiers start over
+			}
+			waited = b
+			notify = b.notify
+			for _, delivery := range b.deliveries {
+				if delivery.ID > after {
+					e.lock.Unlock()
+					l.Infof("delivery #%d received in bucket %s", delivery.ID, name)
+					if format == echoFormatJSON {
+						writeJSON(l, w, delivery)
+					} else {
+						w.WriteHeader(http.StatusOK)
+						writeHookDelivery(w, delivery)
+					}
+					return
+				}
+			}
+		}
+		e.lock.Unlock()
+
+		select {
+		case <-notify:
+		case <-timer.C:
+			l.Infof("no delivery received in bucket %s after %s", name, timeout.String())
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-r.Context().Done():
+			l.Info("client left before any delivery")
+			return
+		}
+	}
+}
+
+func (e *HooksEndpoints) Reset(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue(hooksPathValueBucket)
+
+	e.lock.Lock()
+	if element, found := e.buckets[name]; found {
+		e.delete(element)
+	}
+	e.lock.Unlock()
+
+	l.Infof("bucket %s deleted", name)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (e *HooksEndpoints) Verify(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue(hooksPathValueBucket)
+
+	// do we have a POST method? (mandatory according to RFC)
+	if r.Method != http.MethodPost {
+		l.Warnf("only the POST method is allowed for posting forms, according to RFC 1867 (%s used)", r.Method)
+	}
+
+	// verification config
+	verification, err := parseHookVerificationFromFormData(l, r)
+	if err != nil {
+		errorString := "failed to parse verification config"
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Warn(errorString)
+		return
+	}
+	if err = verification.Validate(); err != nil {
+		errorString := "invalid verification config"
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Warn(errorString)
+		return
+	}
+
+	e.lock.Lock()
+	e.bucket(name).verification = verification
+	e.lock.Unlock()
+
+	l.Infof("signature verification of bucket %s set to profile: %s", name, verification.profile)
+	w.WriteHeader(http.StatusOK)
+}
+
+func writeHookDelivery(w http.ResponseWriter, delivery HookDelivery) {
+	w.Write([]byte(fmt.Sprintf("--- DELIVERY #%d (%s) from %s\n", delivery.ID, delivery.Date.Format(time.RFC3339), delivery.RemoteAddr)))
+	w.Write([]byte(delivery.Method + " " + delivery.URL + "\n"))
+	if delivery.SignatureValid != nil {
+		if *delivery.SignatureValid {
+			w.Write([]byte(fmt.Sprintf("signature (%s): valid\n", delivery.SignatureProfile)))
+		} else {
+			w.Write([]byte(fmt.Sprintf("signature (%s): invalid, %s\n", delivery.SignatureProfile, delivery.SignatureError)))
+		}
+	}
+	writeHeaders(w, delivery.Headers)
+	w.Write([]byte("--- BODY\n"))
+	if len(delivery.Body) == 0 {
+		w.Write([]byte(">>>>> EMPTY REQUEST BODY <<<<<"))
+	} else {
+		w.Write([]byte(delivery.Body))
+	}
+	w.Write([]byte("\n\n"))
+}
+
+type hookVerification struct {
+	profile   string
+	secret    string
+	header    string
+	algorithm string
+	encoding  string
+	tolerance time.Duration
+}
+
+func parseHookVerificationFromFormData(l *log.Entry, r *http.Request) (v hookVerification, err error) {
+	l.Debug("parsing verification configuration")
+	// parse form
+	if err = r.ParseMultipartForm(MaxFormSize); err != nil {
+		return
+	}
+
+	v.profile = strings.TrimSpace(r.FormValue(hooksFormDataProfile))
+	v.secret = r.FormValue(hooksFormDataSecret) // spaces may be part of the secret
+	v.header = strings.TrimSpace(r.FormValue(hooksFormDataHeader))
+	v.algorithm = strings.ToLower(strings.TrimSpace(r.FormValue(hooksFormDataAlgorithm)))
+	v.encoding = strings.ToLower(strings.TrimSpace(r.FormValue(hooksFormDataEncoding)))
+	if len(v.profile) == 0 {
+		v.profile = hooksProfileNone
+	}
+	if len(v.algorithm) == 0 {
+		v.algorithm = hooksAlgorithmSHA256
+	}
+	if len(v.encoding) == 0 {
+		v.encoding = hooksEncodingHex
+	}
+
+	// tolerance
+	v.tolerance = hooksDefaultStripeTolerance
+	toleranceString := strings.TrimSpace(r.FormValue(hooksFormDataTolerance))
+	if len(toleranceString) > 0 {
+		if v.tolerance, err = time.ParseDuration(toleranceString); err != nil {
+			return v, errors.WithMessage(err, "failed to parse tolerance to Golang duration")
+		}
+	}
+	return
+}
+
+func (v hookVerification) Validate() error {
+	// profile
+	switch v.profile {
+	case hooksProfileNone:
+		return nil
+	case hooksProfileGitHub, hooksProfileStripe:
+	case hooksProfileGeneric:
+		if len(v.header) == 0 {
+			return errors.Errorf("the signature header must be set with the %s profile", hooksProfileGeneric)
+		}
+		switch v.algorithm {
+		case hooksAlgorithmSHA1, hooksAlgorithmSHA256, hooksAlgorithmSHA512:
+		default:
+			return errors.Errorf("unknown algorithm: %q, must be one of: %s, %s, %s", v.algorithm, hooksAlgorithmSHA1, hooksAlgorithmSHA256, hooksAlgorithmSHA512)
+		}
+		switch v.encoding {
+		case hooksEncodingHex, hooksEncodingBase64:
+		default:
+			return errors.Errorf("unknown encoding: %q, must be one of: %s, %s", v.encoding, hooksEncodingHex, hooksEncodingBase64)
+		}
+	default:
+		return errors.Errorf("unknown profile: %q, must be one of: %s, %s, %s, %s", v.profile, hooksProfileNone, hooksProfileGitHub, hooksProfileStripe, hooksProfileGeneric)
+	}
+	// secret
+	if len(v.secret) == 0 {
+		return errors.New("the signature secret is not set")
+	}
+	// tolerance
+	if v.tolerance < 0 {
+		return errors.Errorf("tolerance is inferior to zero (value: %s)", v.tolerance.String())
+	}
+	return nil
+}
+
+// Verify checks the signature of the payload. An error describing why the
+// signature is invalid is returned, nil if the signature is valid.
+func (v hookVerification) Verify(headers http.Header, body []byte, date time.Time) error {
+	switch v.profile {
+	case hooksProfileGitHub: // X-Hub-Signature-256: sha256=<hex>
+		signature := headers.Get(hooksGitHubSignatureHeader)
+		if len(signature) == 0 {
+			return errors.Errorf("missing %s header", hooksGitHubSignatureHeader)
+		}
+		return v.compare(sha256.New, hex.DecodeString, body, strings.TrimPrefix(signature, hooksAlgorithmSHA256+"="))
+
+	case hooksProfileStripe: // Stripe-Signature: t=<timestamp>,v1=<hex>[,v1=<hex>]
+		header := headers.Get(hooksStripeSignatureHeader)
+		if len(header) == 0 {
+			return errors.Errorf("missing %s header", hooksStripeSignatureHeader)
+		}
+		var timestamp string
+		var signatures []string
+		for _, part := range strings.Split(header, ",") {
+			key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch key {
+			case "t":
+				timestamp = value
+			case "v1":
+				signatures = append(signatures, value)
+			}
+		}
+		if len(timestamp) == 0 {
+			return errors.Errorf("missing timestamp in %s header", hooksStripeSignatureHeader)
+		}
+		if len(signatures) == 0 {
+			return errors.Errorf("missing v1 signature in %s header", hooksStripeSignatureHeader)
+		}
+		if v.tolerance > 0 {
+			seconds, err := strconv.ParseInt(timestamp, 10, 64)
+			if err != nil {
+				return errors.Errorf("invalid timestamp in %s header: %s", hooksStripeSignatureHeader, timestamp)
+			}
+			if age := date.Sub(time.Unix(seconds, 0)).Abs(); age > v.tolerance {
+				return errors.Errorf("timestamp is outside of the tolerance (age: %s)", age.Round(time.Second).String())
+			}
+		}
+		signedPayload := append([]byte(timestamp+"."), body...)
+		var err error
+		for _, signature := range signatures {
+			if err = v.compare(sha256.New, hex.DecodeString, signedPayload, signature); err == nil {
+				return nil
+			}
+		}
+		return err
+
+	case hooksProfileGeneric: // <header>: [<algorithm>=]<hex|base64>
+		signature := headers.Get(v.header)
+		if len(signature) == 0 {
+			return errors.Errorf("missing %s header", v.header)
+		}
+		hashFunc := sha256.New
+		switch v.algorithm {
+		case hooksAlgorithmSHA1:
+			hashFunc = sha1.New
+		case hooksAlgorithmSHA512:
+			hashFunc = sha512.New
+		}
+		decodeFunc := hex.DecodeString
+		if v.encoding == hooksEncodingBase64 {
+			decodeFunc = base64.StdEncoding.DecodeString
+		}
+		return v.compare(hashFunc, decodeFunc, body, strings.TrimPrefix(signature, v.algorithm+"="))
+	}
+	return nil
+}
+
+// compare computes the HMAC of the payload and compares it to the decoded
+// signature.
+func (v hookVerification) compare(hashFunc func() hash.Hash, decodeFunc func(string) ([]byte, error), payload []byte, signature string) error {
+	decodedSignature, err := decodeFunc(signature)
+	if err != nil {
+		return errors.WithMessage(err, "failed to decode signature")
+	}
+	mac := hmac.New(hashFunc, []byte(v.secret))
+	mac.Write(payload)
+	if !hmac.Equal(mac.Sum(nil), decodedSignature) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestHooksBucketsEviction(t *testing.T) {
+	e := NewHooksEndpoints(10, 1024)
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for i := range hooksMaxBuckets {
+		e.bucket(strconv.Itoa(i))
+	}
+	e.bucket("0") // most recently used, bucket "1" is now the least recently used
+	evicted := e.lookup("1")
+	e.bucket("new")
+	if len(e.buckets) != hooksMaxBuckets || e.lru.Len() != hooksMaxBuckets {
+		t.Fatalf("got %d buckets (%d in the LRU list), want %d", len(e.buckets), e.lru.Len(), hooksMaxBuckets)
+	}
+	if e.lookup("1") != nil {
+		t.Error("the least recently used bucket is still there")
+	}
+	if e.lookup("0") == nil || e.lookup("new") == nil {
+		t.Error("the recently used buckets have been deleted")
+	}
+	select {
+	case <-evicted.notify:
+	default:
+		t.Error("the waiters of the deleted bucket have not been woken up")
+	}
+}
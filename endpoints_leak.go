@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// params
+	leakQueryParamFrequency string = "frequency"
+	leakQueryParamMax       string = "max"
+	leakQueryParamThread    string = "thread"
+
+	// leaked resources
+	leakTypeFile      string = "file"
+	leakTypeSocket    string = "socket"
+	leakTypeGoroutine string = "goroutine"
+	leakTypeThread    string = "thread"
+
+	leakDialTimeout time.Duration = 10 * time.Second
+	// maximum number of leaked threads, below the 10000 threads limit of the
+	// Go runtime (see debug.SetMaxThreads) which crashes the server
+	leakMaxThreads int = 9000
+)
+
+type LeakEndpoints struct {
+	lock         *sync.Mutex
+	files        []*os.File
+	conns        []net.Conn
+	nbGoroutines int
+	nbThreads    int
+	release      chan struct{} // closed to release the leaked goroutines and threads
+	stopFuncs    []func()
+	workerID     int
+	workers      *sync.WaitGroup
+	leakers      []*LeakWorkerState
+}
+
+// LeakState describes the resources leaked on purpose, the usage of the
+// process, and the leak workers currently running.
+type LeakState struct {
+	LeakedFiles      int               `json:"leaked_files"`
+	LeakedSockets    int               `json:"leaked_sockets"`
+	LeakedGoroutines int               `json:"leaked_goroutines"`
+	LeakedThreads    int               `json:"leaked_threads"`
+	OpenFiles        int               `json:"open_files"`       // file descriptors used by the process
+	FilesLimit       uint64            `json:"files_limit"`      // RLIMIT_NOFILE soft limit, 0 if unknown
+	FilesHardLimit   uint64            `json:"files_hard_limit"` // RLIMIT_NOFILE hard limit, 0 if unknown
+	Goroutines       int               `json:"goroutines"`
+	Threads          int               `json:"threads"` // OS threads of the process, 0 if unknown
+	LeakWorkers      []LeakWorkerState `json:"leak_workers"`
+}
+
+type LeakWorkerState struct {
+	ID        int       `json:"id"`
+	Type      string    `json:"type"`
+	Started   time.Time `json:"started"`
+	Count     int       `json:"count"`
+	Frequency string    `json:"frequency"`
+	Max       int       `json:"max"`
+	Host      string    `json:"host,omitempty"`
+	Leaked    int       `json:"leaked"`
+	Error     string    `json:"error,omitempty"` // why the worker stopped before reaching the max
+}
+
+func NewLeakEndpoints() *LeakEndpoints {
+	return &LeakEndpoints{
+		lock:    &sync.Mutex{},
+		release: make(chan struct{}),
+		workers: &sync.WaitGroup{},
+	}
+}
+
+func (e *LeakEndpoints) File(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	worker, frequency, ok := parseLeakWorker(l, w, r, leakTypeFile)
+	if !ok {
+		return
+	}
+	e.startLeakWorker(worker, frequency, e.leakFile)
+	l.Infof("starting file descriptor leak with frequency of %d/%s (max: %d)", worker.Count, frequency.String(), worker.Max)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (e *LeakEndpoints) Socket(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	worker, frequency, ok := parseLeakWorker(l, w, r, leakTypeSocket)
+	if !ok {
+		return
+	}
+	// parsing host
+	worker.Host = r.URL.Query().Get(queryParamHost)
+	if _, _, err := net.SplitHostPort(worker.Host); err != nil {
+		errorString := "host is incorrect, must be host:port: " + err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return
+	}
+	e.startLeakWorker(worker, frequency, func() error { return e.leakSocket(worker.Host) })
+	l.Infof("starting socket leak to %s with frequency of %d/%s (max: %d)", worker.Host, worker.Count, frequency.String(), worker.Max)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (e *LeakEndpoints) Goroutine(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	// parsing thread
+	var thread bool
+	if threadString := r.URL.Query().Get(leakQueryParamThread); len(threadString) > 0 {
+		var err error
+		if thread, err = strconv.ParseBool(threadString); err != nil {
+			errorString := "thread is incorrect: " + err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+	}
+	leakType := leakTypeGoroutine
+	if thread {
+		leakType = leakTypeThread
+	}
+	worker, frequency, ok := parseLeakWorker(l, w, r, leakType)
+	if !ok {
+		return
+	}
+	e.startLeakWorker(worker, frequency, func() error { return e.leakGoroutine(thread) })
+	l.Infof("starting %s leak with frequency of %d/%s (max: %d)", leakType, worker.Count, frequency.String(), worker.Max)
+	w.WriteHeader(http.StatusOK)
+}
+
+// parseLeakWorker parses the count, frequency and max query parameters. If
+// they are incorrect, the error is written to the client and ok is false.
+func parseLeakWorker(l *log.Entry, w http.ResponseWriter, r *http.Request, leakType string) (worker LeakWorkerState, frequency time.Duration, ok bool) {
+	l.Debug("parsing query variables")
+	query := r.URL.Query()
+	worker = LeakWorkerState{Type: leakType, Started: time.Now(), Count: 1}
+	// count
+	if countString := query.Get(queryParamCount); len(countString) > 0 {
+		if !positiveIntegerRegex.MatchString(countString) {
+			errorString := "count doesn't match regex: " + positiveIntegerRegex.String()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+		var err error
+		if worker.Count, err = strconv.Atoi(countString); err != nil || worker.Count < 1 {
+			errorString := "count must be at least 1"
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+	}
+	// frequency
+	if frequencyString := query.Get(leakQueryParamFrequency); len(frequencyString) > 0 {
+		var err error
+		if frequency, err = time.ParseDuration(frequencyString); err != nil {
+			errorString := "leak frequency is incorrect: " + err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		} else if frequency < 0 {
+			errorString := "leak frequency is inferior to zero: " + frequency.String()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+	}
+	worker.Frequency = frequency.String()
+	// max
+	if maxString := query.Get(leakQueryParamMax); len(maxString) > 0 {
+		if !positiveIntegerRegex.MatchString(maxString) {
+			errorString := "max doesn't match regex: " + positiveIntegerRegex.String()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+		worker.Max, _ = strconv.Atoi(maxString) // can't fail thanks to the regexp
+	}
+	if leakType == leakTypeGoroutine && frequency == 0 && worker.Max == 0 {
+		errorString := "max must be set when the frequency is 0, goroutines would be leaked without pause until the server runs out of memory"
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return
+	}
+	if leakType == leakTypeThread {
+		if worker.Max == 0 {
+			worker.Max = leakMaxThreads
+		} else if worker.Max > leakMaxThreads {
+			errorString := fmt.Sprintf("max is superior to %d, the Go runtime crashes the server above 10000 threads", leakMaxThreads)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+	}
+	return worker, frequency, true
+}
+
+// startLeakWorker calls leak count times per frequency, until the max is
+// reached, leak fails, or the worker is stopped.
+func (e *LeakEndpoints) startLeakWorker(state LeakWorkerState, frequency time.Duration, leak func() error) {
+	// stop
+	stopCh := make(chan struct{})
+	e.lock.Lock()
+	e.stopFuncs = append(e.stopFuncs, sync.OnceFunc(func() { close(stopCh) }))
+	e.workerID++
+	state.ID = e.workerID
+	worker := &state
+	e.leakers = append(e.leakers, worker)
+	e.lock.Unlock()
+
+	// leak worker
+	e.workers.Add(1)
+	go func() {
+		defer e.workers.Done()
+
+		// logger
+		l := log.WithFields(log.Fields{"leak_worker_id": worker.ID, "type": worker.Type})
+		if frequency > 0 {
+			l = l.WithField("frequency", frequency.String())
+		}
+
+		// worker loop
+		for {
+			for range worker.Count {
+				select {
+				case <-stopCh:
+					return
+				default:
+				}
+				if err := leak(); err != nil {
+					l.WithError(err).Errorf("failed to leak %s, stopping the leak worker: %s", worker.Type, e.leakStats())
+					e.lock.Lock()
+					worker.Error = err.Error()
+					e.lock.Unlock()
+					return // the worker is still reported until reset
+				}
+				e.lock.Lock()
+				worker.Leaked++
+				done := worker.Max > 0 && worker.Leaked >= worker.Max
+				e.lock.Unlock()
+				if done {
+					l.Infof("max reached, stopping the leak worker: %s", e.leakStats())
+					return
+				}
+			}
+			if frequency == 0 {
+				continue // leaking without pause, logged when the worker stops
+			}
+			l.Info(e.leakStats())
+			select {
+			case <-stopCh:
+				return
+			case <-time.After(frequency):
+			}
+		}
+	}()
+}
+
+func (e *LeakEndpoints) leakFile() error {
+	file, err := os.Open(os.DevNull)
+	if err != nil {
+		return err
+	}
+	e.lock.Lock()
+	e.files = append(e.files, file)
+	e.lock.Unlock()
+	return nil
+}
+
+// leakSocket opens a TCP connection to the host, and keeps it idle.
+func (e *LeakEndpoints) leakSocket(host string) error {
+	conn, err := net.DialTimeout("tcp", host, leakDialTimeout)
+	if err != nil {
+		return err
+	}
+	e.lock.Lock()
+	e.conns = append(e.conns, conn)
+	e.lock.Unlock()
+	return nil
+}
+
+// leakGoroutine starts a goroutine blocked until reset. If thread is true,
+// the goroutine is locked to its OS thread, so each leaked goroutine holds a
+// thread, up to leakMaxThreads (across all the workers).
+func (e *LeakEndpoints) leakGoroutine(thread bool) error {
+	e.lock.Lock()
+	release := e.release
+	if thread && e.nbThreads >= leakMaxThreads {
+		e.lock.Unlock()
+		return errors.Errorf("the %d leaked threads limit is reached", leakMaxThreads)
+	} else if thread {
+		e.nbThreads++
+	} else {
+		e.nbGoroutines++
+	}
+	e.lock.Unlock()
+	go func() {
+		if thread {
+			runtime.LockOSThread() // never unlocked, the thread exits with the goroutine
+		}
+		<-release
+	}()
+	return nil
+}
+
+func (e *LeakEndpoints) Reset(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	// stopping leak workers
+	e.lock.Lock()
+	for _, stopFunc := range e.stopFuncs {
+		stopFunc()
+	}
+	e.stopFuncs = []func(){}
+	e.leakers = nil
+	e.lock.Unlock()
+	e.workers.Wait()
+
+	// releasing leaks
+	e.lock.Lock()
+	for _, file := range e.files {
+		file.Close()
+	}
+	for _, conn := range e.conns {
+		conn.Close()
+	}
+	close(e.release)
+	e.files, e.conns, e.nbGoroutines, e.nbThreads, e.release = nil, nil, 0, 0, make(chan struct{})
+	e.lock.Unlock()
+
+	leakStats := e.leakStats()
+	l.Info(leakStats)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(leakStats))
+}
+
+func (e *LeakEndpoints) Status(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	leakStats := e.leakStats()
+	l.Info(leakStats)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(leakStats))
+}
+
+func (e *LeakEndpoints) State() LeakState {
+	state := LeakState{
+		OpenFiles:  openFiles(),
+		Goroutines: runtime.NumGoroutine(),
+		Threads:    processThreads(),
+	}
+	state.FilesLimit, state.FilesHardLimit, _ = filesLimits()
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	state.LeakedFiles = len(e.files)
+	state.LeakedSockets = len(e.conns)
+	state.LeakedGoroutines = e.nbGoroutines
+	state.LeakedThreads = e.nbThreads
+	state.LeakWorkers = []LeakWorkerState{}
+	for _, worker := range e.leakers {
+		state.LeakWorkers = append(state.LeakWorkers, *worker)
+	}
+	return state
+}
+
+func (e *LeakEndpoints) leakStats() string {
+	state := e.State()
+	var stats strings.Builder
+	fmt.Fprintf(&stats, "leak status: File descriptors: %d open", state.OpenFiles)
+	if state.FilesLimit > 0 {
+		fmt.Fprintf(&stats, " out of %d (%.2f%%, hard limit: %d)", state.FilesLimit, float64(state.OpenFiles)/float64(state.FilesLimit)*100, state.FilesHardLimit)
+	} else {
+		stats.WriteString(" out of unknown")
+	}
+	fmt.Fprintf(&stats, ", Goroutines: %d", state.Goroutines)
+	if state.Threads > 0 {
+		fmt.Fprintf(&stats, ", OS threads: %d", state.Threads)
+	}
+	fmt.Fprintf(&stats, ", Leaked: %d file(s), %d socket(s), %d goroutine(s), %d thread(s)", state.LeakedFiles, state.LeakedSockets, state.LeakedGoroutines, state.LeakedThreads)
+	if len(state.LeakWorkers) > 0 {
+		fmt.Fprintf(&stats, ", %d leak worker(s)", len(state.LeakWorkers))
+	}
+	return stats.String()
+}
+
+// openFiles returns the number of file descriptors used by the process, 0 if
+// unknown.
+func openFiles() int {
+	entries, err := os.ReadDir("/dev/fd")
+	if err != nil {
+		return 0
+	}
+	return len(entries) - 1 // without the descriptor used to read the folder
+}
+
+// processThreads returns the number of OS threads of the process, 0 if
+// unknown (only available on Linux).
+func processThreads() int {
+	file, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value, found := strings.CutPrefix(scanner.Text(), "Threads:"); found {
+			threads, _ := strconv.Atoi(strings.TrimSpace(value))
+			return threads
+		}
+	}
+	return 0
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "github.com/pkg/errors"
+
+// filesLimits is not available on this platform, the limits are reported as
+// unknown.
+func filesLimits() (soft, hard uint64, err error) {
+	return 0, 0, errors.New("files limits are not supported on this platform")
+}
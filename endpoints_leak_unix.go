@@ -0,0 +1,14 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// filesLimits returns the soft and hard RLIMIT_NOFILE limits.
+func filesLimits() (soft, hard uint64, err error) {
+	var limit syscall.Rlimit
+	if err = syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return
+	}
+	return uint64(limit.Cur), uint64(limit.Max), nil
+}
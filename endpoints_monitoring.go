@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -28,6 +29,44 @@ type MonitoringEndpoints struct {
 	startup   *monitoringEndpoints
 	liveness  *monitoringEndpoints
 	readiness *monitoringEndpoints
+	stateNotifier
+}
+
+// MonitoringState describes the current configuration of the probes.
+type MonitoringState struct {
+	Startup   MonitoringEndpointState `json:"startup"`
+	Liveness  MonitoringEndpointState `json:"liveness"`
+	Readiness MonitoringEndpointState `json:"readiness"`
+}
+
+type MonitoringEndpointState struct {
+	StatusOk    int    `json:"status_ok"`
+	StatusError int    `json:"status_error"`
+	Fail        bool   `json:"fail"`
+	FailNb      int    `json:"nb_failures"`
+	Delay       string `json:"delay"`
+}
+
+func (e *MonitoringEndpoints) State() MonitoringState {
+	return MonitoringState{
+		Startup:   e.startup.State(),
+		Liveness:  e.liveness.State(),
+		Readiness: e.readiness.State(),
+	}
+}
+
+// Restore sets the probes configuration described by the state.
+func (e *MonitoringEndpoints) Restore(state MonitoringState) error {
+	if err := e.startup.Restore(state.Startup); err != nil {
+		return errors.WithMessage(err, "failed to restore startup probe")
+	}
+	if err := e.liveness.Restore(state.Liveness); err != nil {
+		return errors.WithMessage(err, "failed to restore liveness probe")
+	}
+	if err := e.readiness.Restore(state.Readiness); err != nil {
+		return errors.WithMessage(err, "failed to restore readiness probe")
+	}
+	return nil
 }
 
 func (e *MonitoringEndpoints) Startup(l *log.Entry, w http.ResponseWriter, r *http.Request) {
@@ -42,7 +81,15 @@ func (e *MonitoringEndpoints) Readiness(l *log.Entry, w http.ResponseWriter, r *
 	e.endpoint(e.readiness, l, w, r)
 }
 
-func (*MonitoringEndpoints) endpoint(e *monitoringEndpoints, l *log.Entry, w http.ResponseWriter, r *http.Request) {
+func (m *MonitoringEndpoints) endpoint(e *monitoringEndpoints, l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	// answering may change the state (remaining failures)
+	state := e.State()
+	defer func() {
+		if e.State() != state {
+			m.changed()
+		}
+	}()
+
 	switch r.Method {
 	case http.MethodGet:
 		e.Endpoint(l, w, r)
@@ -76,6 +123,33 @@ type monitoringEndpoints struct {
 	delay      time.Duration
 }
 
+func (e *monitoringEndpoints) State() MonitoringEndpointState {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return MonitoringEndpointState{
+		StatusOk:    e.okStatus,
+		StatusError: e.failStatus,
+		Fail:        e.fail,
+		FailNb:      e.failNb,
+		Delay:       e.delay.String(),
+	}
+}
+
+func (e *monitoringEndpoints) Restore(state MonitoringEndpointState) error {
+	delay, err := time.ParseDuration(state.Delay)
+	if err != nil {
+		return errors.WithMessage(err, "failed to parse delay")
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.okStatus = state.StatusOk
+	e.failStatus = state.StatusError
+	e.fail = state.Fail
+	e.failNb = state.FailNb
+	e.delay = delay
+	return nil
+}
+
 func (e *monitoringEndpoints) Endpoint(l *log.Entry, w http.ResponseWriter, r *http.Request) {
 	// delay
 	if e.delay > 0 { // not sure this improves a lot
@@ -8,6 +8,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -22,6 +23,25 @@ type RAMEndpoints struct {
 	stopFuncs []func()
 	workerID  int
 	workers   *sync.WaitGroup
+	leakers   []RAMLeakWorkerState
+	stateNotifier
+}
+
+// RAMState describes the memory leaked on purpose, and the leak workers
+// currently running.
+type RAMState struct {
+	Leaked        int                  `json:"leaked"`
+	LeakedHuman   string               `json:"leaked_human"`
+	NbAllocations int                  `json:"nb_allocations"`
+	Alloc         uint64               `json:"alloc"`
+	LeakWorkers   []RAMLeakWorkerState `json:"leak_workers"`
+}
+
+type RAMLeakWorkerState struct {
+	ID        int       `json:"id"`
+	Started   time.Time `json:"started"`
+	Size      int       `json:"size"`
+	Frequency string    `json:"frequency"`
 }
 
 func NewRAMEndpoints() *RAMEndpoints {
@@ -50,6 +70,7 @@ func (e *RAMEndpoints) Increase(l *log.Entry, w http.ResponseWriter, r *http.Req
 	// increasing memory usage
 	l.Infof("increasing memory usage with %s (%d Bytes)", SizeToHumanReadable(float64(size)), size)
 	memStats := e.leak(size)
+	e.changed()
 	l.Info(memStats)
 
 	w.WriteHeader(http.StatusOK)
@@ -88,6 +109,7 @@ func (e *RAMEndpoints) Decrease(l *log.Entry, w http.ResponseWriter, r *http.Req
 		}
 	}
 	e.lock.Unlock()
+	e.changed()
 
 	// display memory stats
 	memStats := e.gc()
@@ -143,11 +165,26 @@ func (e *RAMEndpoints) Leak(l *log.Entry, w http.ResponseWriter, r *http.Request
 		}
 	}
 
+	// leak worker
+	e.startLeakWorker(size, leakFrequency)
+	e.changed()
+	l.Infof("starting memory leak with frequency of %s/%s", SizeToHumanReadable(float64(size)), leakFrequency.String())
+	w.WriteHeader(http.StatusOK)
+}
+
+func (e *RAMEndpoints) startLeakWorker(size int, leakFrequency time.Duration) {
 	// stop
 	stopCh := make(chan int)
 	e.lock.Lock()
 	e.stopFuncs = append(e.stopFuncs, func() { stopCh <- 0 })
 	e.workerID++
+	workerID := e.workerID
+	e.leakers = append(e.leakers, RAMLeakWorkerState{
+		ID:        workerID,
+		Started:   time.Now(),
+		Size:      size,
+		Frequency: leakFrequency.String(),
+	})
 	e.lock.Unlock()
 
 	// leak worker
@@ -156,7 +193,7 @@ func (e *RAMEndpoints) Leak(l *log.Entry, w http.ResponseWriter, r *http.Request
 		defer e.workers.Done()
 
 		// logger
-		l := log.WithField("leak_worker_id", e.workerID)
+		l := log.WithField("leak_worker_id", workerID)
 		if leakFrequency > 0 {
 			l = l.WithField("frequency", leakFrequency.String())
 		}
@@ -175,8 +212,6 @@ func (e *RAMEndpoints) Leak(l *log.Entry, w http.ResponseWriter, r *http.Request
 			}
 		}
 	}()
-	l.Infof("starting memory leak with frequency of %s/%s", SizeToHumanReadable(float64(size)), leakFrequency.String())
-	w.WriteHeader(http.StatusOK)
 }
 
 func (e *RAMEndpoints) leak(size int) string {
@@ -187,6 +222,7 @@ func (e *RAMEndpoints) leak(size int) string {
 	e.lock.Lock()
 	e.leaks = append(e.leaks, leak)
 	e.lock.Unlock()
+	e.changed()
 
 	// display memory stats
 	return e.memoryStats()
@@ -199,6 +235,7 @@ func (e *RAMEndpoints) Reset(l *log.Entry, w http.ResponseWriter, r *http.Reques
 		go stopFunc()
 	}
 	e.stopFuncs = []func(){}
+	e.leakers = nil
 	e.lock.Unlock()
 	e.workers.Wait()
 
@@ -206,6 +243,7 @@ func (e *RAMEndpoints) Reset(l *log.Entry, w http.ResponseWriter, r *http.Reques
 	e.lock.Lock()
 	e.leaks = [][]byte{}
 	e.lock.Unlock()
+	e.changed()
 
 	// display memory stats
 	memStats := e.gc()
@@ -222,6 +260,40 @@ func (e *RAMEndpoints) Status(l *log.Entry, w http.ResponseWriter, r *http.Reque
 	w.Write([]byte(memStats))
 }
 
+func (e *RAMEndpoints) State() RAMState {
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	state := RAMState{
+		NbAllocations: len(e.leaks),
+		Alloc:         memory.Alloc,
+		LeakWorkers:   append([]RAMLeakWorkerState{}, e.leakers...),
+	}
+	for _, leak := range e.leaks {
+		state.Leaked += len(leak)
+	}
+	state.LeakedHuman = SizeToHumanReadable(float64(state.Leaked))
+	return state
+}
+
+// Restore leaks the memory and starts the leak workers described by the
+// state.
+func (e *RAMEndpoints) Restore(state RAMState) error {
+	if state.Leaked > 0 {
+		e.leak(state.Leaked)
+	}
+	for _, worker := range state.LeakWorkers {
+		leakFrequency, err := time.ParseDuration(worker.Frequency)
+		if err != nil {
+			return errors.WithMessagef(err, "failed to parse frequency of leak worker %d", worker.ID)
+		}
+		e.startLeakWorker(worker.Size, leakFrequency)
+	}
+	return nil
+}
+
 func (e RAMEndpoints) gc() string {
 	runtime.GC()
 	return e.memoryStats()
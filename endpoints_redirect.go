@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// params
+	redirectQueryParamHops     string = "hops"
+	redirectQueryParamHop      string = "hop"
+	redirectQueryParamAbsolute string = "absolute"
+	redirectQueryParamScheme   string = "scheme"
+	redirectQueryParamURL      string = "url"
+	redirectQueryParamLoop     string = "loop"
+
+	// defaults
+	redirectDefaultHops int = 1
+	redirectDefaultCode int = http.StatusFound
+)
+
+// redirect answers with a chain of redirects, each hop redirecting to the
+// next one (on the same path) until the last one, which answers with a 200,
+// or redirects to the final URL.
+func redirect(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	config, err := parseRedirectConfig(r.URL.Query())
+	if err != nil {
+		errorString := "invalid redirect config"
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Warn(errorString)
+		return
+	}
+
+	// end of the chain
+	if config.hop >= config.hops {
+		l.Infof("end of the redirect chain, after %d hops", config.hops)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("redirect chain completed after " + strconv.Itoa(config.hops) + " hops\n"))
+		return
+	}
+
+	// next hop
+	var location string
+	if config.hop+1 == config.hops && len(config.url) > 0 { // last hop, to the final URL
+		location = config.url
+	} else {
+		next := config.hop + 1
+		if config.hop+1 == config.hops && config.loop {
+			next = 0 // back to the first hop
+		}
+		query := r.URL.Query()
+		query.Set(redirectQueryParamHop, strconv.Itoa(next))
+		u := url.URL{Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: query.Encode()}
+		if config.absolute {
+			u.Scheme, u.Host = config.scheme, config.host
+			if len(u.Scheme) == 0 {
+				u.Scheme = "http"
+				if r.TLS != nil {
+					u.Scheme = "https"
+				}
+			}
+			if len(u.Host) == 0 {
+				u.Host = r.Host
+			}
+		}
+		location = u.String()
+	}
+	l.Infof("redirecting (hop %d/%d) to: %s", config.hop+1, config.hops, location)
+	w.Header().Set("Location", location)
+	w.WriteHeader(config.code)
+}
+
+type redirectConfig struct {
+	hops     int
+	hop      int
+	code     int
+	absolute bool
+	scheme   string
+	host     string
+	url      string
+	loop     bool
+}
+
+func parseRedirectConfig(query url.Values) (c redirectConfig, err error) {
+	// hops
+	c.hops = redirectDefaultHops
+	if hopsString := query.Get(redirectQueryParamHops); len(hopsString) > 0 {
+		if !positiveIntegerRegex.MatchString(hopsString) {
+			return c, errors.Errorf("hops doesn't match regex: %s (value: %s)", positiveIntegerRegex.String(), hopsString)
+		}
+		c.hops, _ = strconv.Atoi(hopsString) // can't fail thanks to the regexp
+	}
+	if hopString := query.Get(redirectQueryParamHop); len(hopString) > 0 {
+		if !positiveIntegerRegex.MatchString(hopString) {
+			return c, errors.Errorf("hop doesn't match regex: %s (value: %s)", positiveIntegerRegex.String(), hopString)
+		}
+		c.hop, _ = strconv.Atoi(hopString) // can't fail thanks to the regexp
+	}
+	// status code
+	c.code = redirectDefaultCode
+	if codeString := query.Get(queryParamCode); len(codeString) > 0 {
+		switch c.code, _ = strconv.Atoi(codeString); c.code {
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			return c, errors.Errorf("unsupported redirect status code: %q, must be one of: 301, 302, 303, 307, 308", codeString)
+		}
+	}
+	// location
+	if absoluteString := query.Get(redirectQueryParamAbsolute); len(absoluteString) > 0 {
+		if c.absolute, err = strconv.ParseBool(absoluteString); err != nil {
+			return c, errors.WithMessage(err, "failed to parse absolute to boolean")
+		}
+	}
+	c.scheme = query.Get(redirectQueryParamScheme)
+	switch c.scheme {
+	case "":
+	case "http", "https":
+		c.absolute = true
+	default:
+		return c, errors.Errorf("unsupported scheme: %q, must be one of: http, https", c.scheme)
+	}
+	if c.host = query.Get(queryParamHost); len(c.host) > 0 {
+		c.absolute = true
+	}
+	// final URL
+	if c.url = query.Get(redirectQueryParamURL); len(c.url) > 0 {
+		u, err := url.Parse(c.url)
+		if err != nil {
+			return c, errors.WithMessage(err, "invalid URL")
+		}
+		if !u.IsAbs() {
+			return c, errors.New("the URL must contain the scheme (i.e.: http(s)://)")
+		}
+	}
+	// loop
+	if loopString := query.Get(redirectQueryParamLoop); len(loopString) > 0 {
+		if c.loop, err = strconv.ParseBool(loopString); err != nil {
+			return c, errors.WithMessage(err, "failed to parse loop to boolean")
+		}
+	}
+	if c.loop && len(c.url) > 0 {
+		return c, errors.New("loop and url can't be set together")
+	}
+	if c.loop && c.hops == 0 {
+		return c, errors.New("hops must be superior to zero to loop")
+	}
+	return c, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestParseRedirectConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    redirectConfig
+		wantErr bool
+	}{
+		{name: "defaults", query: "", want: redirectConfig{hops: 1, code: http.StatusFound}},
+		{name: "hops and hop", query: "hops=5&hop=2", want: redirectConfig{hops: 5, hop: 2, code: http.StatusFound}},
+		{name: "zero hops", query: "hops=0", want: redirectConfig{code: http.StatusFound}},
+		{name: "negative hops", query: "hops=-1", wantErr: true},
+		{name: "invalid hop", query: "hop=abc", wantErr: true},
+		{name: "permanent redirect", query: "code=308", want: redirectConfig{hops: 1, code: http.StatusPermanentRedirect}},
+		{name: "unsupported code", query: "code=200", wantErr: true},
+		{name: "invalid code", query: "code=abc", wantErr: true},
+		{name: "absolute", query: "absolute=true", want: redirectConfig{hops: 1, code: http.StatusFound, absolute: true}},
+		{name: "invalid absolute", query: "absolute=maybe", wantErr: true},
+		{name: "scheme implies absolute", query: "scheme=https", want: redirectConfig{hops: 1, code: http.StatusFound, absolute: true, scheme: "https"}},
+		{name: "unsupported scheme", query: "scheme=ftp", wantErr: true},
+		{name: "host implies absolute", query: "host=example.com:8443", want: redirectConfig{hops: 1, code: http.StatusFound, absolute: true, host: "example.com:8443"}},
+		{name: "final URL", query: "hops=3&url=https%3A%2F%2Fexample.com%2Fdone", want: redirectConfig{hops: 3, code: http.StatusFound, url: "https://example.com/done"}},
+		{name: "relative final URL", query: "url=%2Fdone", wantErr: true},
+		{name: "invalid final URL", query: "url=http%3A%2F%2F%25zz", wantErr: true},
+		{name: "loop", query: "hops=2&loop=true", want: redirectConfig{hops: 2, code: http.StatusFound, loop: true}},
+		{name: "invalid loop", query: "loop=maybe", wantErr: true},
+		{name: "loop with final URL", query: "loop=true&url=https%3A%2F%2Fexample.com", wantErr: true},
+		{name: "loop without hops", query: "hops=0&loop=true", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := url.ParseQuery(test.query)
+			if err != nil {
+				t.Fatalf("invalid test query: %v", err)
+			}
+			config, err := parseRedirectConfig(query)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got: %+v", config)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if config != test.want {
+				t.Errorf("got %+v, want %+v", config, test.want)
+			}
+		})
+	}
+}
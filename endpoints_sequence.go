@@ -0,0 +1,525 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// form data keys
+	sequenceFormDataPath      string = "path"
+	sequenceFormDataMode      string = "mode"
+	sequenceFormDataKey       string = "key"
+	sequenceFormDataKeyHeader string = "key_header"
+	sequenceFormDataResponse  string = "response"
+	// query params
+	sequenceQueryParamPath string = "path"
+
+	// modes
+	sequenceModeSequence string = "sequence"
+	sequenceModeCycle    string = "cycle"
+	// keys
+	sequenceKeyNone   string = "none"
+	sequenceKeyClient string = "client"
+	sequenceKeyHeader string = "header"
+
+	// response separator (status,delay,body)
+	sequenceResponseSeparator string = ","
+
+	// number of positions tracked per sequence, the least recently used are forgotten
+	sequenceMaxPositions int = 10000
+)
+
+// SequenceEndpoints holds the response sequences attached to routes. A
+// sequence can be attached to any route wrapped by the middleware (it takes
+// precedence over the endpoint until it is exhausted) or to a path under the
+// mock prefix.
+type SequenceEndpoints struct {
+	lock      *sync.Mutex
+	sequences map[string]*sequence
+	routes    map[string]bool // wrapped by the middleware, patterns ending with '/' match sub paths
+	stateNotifier
+}
+
+func NewSequenceEndpoints() *SequenceEndpoints {
+	return &SequenceEndpoints{
+		lock:      &sync.Mutex{},
+		sequences: map[string]*sequence{},
+		routes:    map[string]bool{},
+	}
+}
+
+type sequence struct {
+	path      string
+	mode      string
+	key       string
+	keyHeader string
+	responses []sequenceResponse
+	positions map[string]*list.Element // by key, in the LRU list
+	lru       *list.List               // of *sequencePosition, most recently used first
+}
+
+// sequencePosition is the position in the sequence of a key.
+type sequencePosition struct {
+	key      string
+	position int
+}
+
+// newSequence returns a sequence with no position tracked.
+func newSequence(path, mode, key, keyHeader string) *sequence {
+	return &sequence{
+		path:      path,
+		mode:      mode,
+		key:       key,
+		keyHeader: keyHeader,
+		positions: map[string]*list.Element{},
+		lru:       list.New(),
+	}
+}
+
+type sequenceResponse struct {
+	status int
+	delay  time.Duration
+	body   string
+}
+
+func (s sequenceResponse) String() string {
+	responseString := strconv.Itoa(s.status)
+	if s.delay > 0 {
+		responseString += " after " + s.delay.String()
+	}
+	if len(s.body) > 0 {
+		responseString += fmt.Sprintf(" with body of %d Bytes", len(s.body))
+	}
+	return responseString
+}
+
+// SequenceState describes a sequence attached to a route.
+type SequenceState struct {
+	Path      string                  `json:"path"`
+	Mode      string                  `json:"mode"`
+	Key       string                  `json:"key"`
+	KeyHeader string                  `json:"key_header,omitempty"`
+	Responses []SequenceResponseState `json:"responses"`
+	Positions map[string]int          `json:"positions"`
+}
+
+type SequenceResponseState struct {
+	Status int    `json:"status"`
+	Delay  string `json:"delay"`
+	Body   string `json:"body"`
+}
+
+// State returns the configured sequences, ordered by path.
+func (e *SequenceEndpoints) State() []SequenceState {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	state := make([]SequenceState, 0, len(e.sequences))
+	for _, s := range e.sequences {
+		sequenceState := SequenceState{
+			Path:      s.path,
+			Mode:      s.mode,
+			Key:       s.key,
+			KeyHeader: s.keyHeader,
+			Positions: map[string]int{},
+		}
+		for _, response := range s.responses {
+			sequenceState.Responses = append(sequenceState.Responses, SequenceResponseState{
+				Status: response.status,
+				Delay:  response.delay.String(),
+				Body:   response.body,
+			})
+		}
+		for key, element := range s.positions {
+			sequenceState.Positions[key] = element.Value.(*sequencePosition).position
+		}
+		state = append(state, sequenceState)
+	}
+	sort.Slice(state, func(i, j int) bool { return state[i].Path < state[j].Path })
+	return state
+}
+
+// Restore attaches the sequences described by the state.
+func (e *SequenceEndpoints) Restore(state []SequenceState) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for _, sequenceState := range state {
+		s := newSequence(sequenceState.Path, sequenceState.Mode, sequenceState.Key, sequenceState.KeyHeader)
+		for i, responseState := range sequenceState.Responses {
+			response := sequenceResponse{
+				status: responseState.Status,
+				body:   responseState.Body,
+			}
+			var err error
+			if response.delay, err = time.ParseDuration(responseState.Delay); err != nil {
+				return errors.WithMessagef(err, "failed to parse delay of response %d of sequence %s", i+1, s.path)
+			}
+			s.responses = append(s.responses, response)
+		}
+		if err := s.Validate(); err != nil {
+			return errors.WithMessagef(err, "invalid sequence %s", s.path)
+		}
+		if !e.supports(s.path) {
+			log.Warnf("sequences can't be attached to path %s anymore, the sequence is dropped", s.path)
+			continue
+		}
+		for key, position := range sequenceState.Positions {
+			s.setPosition(key, position)
+		}
+		e.sequences[s.path] = s
+	}
+	return nil
+}
+
+// MiddleWare answers with the next response of the sequence attached to the
+// request path, if any. Once a sequence (not cycling) is exhausted, requests
+// are sent to the downstream endpoint. The route pattern is registered, so
+// that sequences can only be attached to the wrapped routes.
+func (e *SequenceEndpoints) MiddleWare(pattern string, downstream func(*log.Entry, http.ResponseWriter, *http.Request)) func(*log.Entry, http.ResponseWriter, *http.Request) {
+	e.lock.Lock()
+	e.routes[pattern] = true
+	e.lock.Unlock()
+	return func(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+		response, ok := e.next(r)
+		if !ok {
+			downstream(l, w, r)
+			return
+		}
+		e.changed()
+
+		// answering
+		if response.delay > 0 {
+			l.Infof("sequence response will be sent in %s", response.delay.String())
+			time.Sleep(response.delay)
+		}
+		l.Infof("answering with sequence response: %s", response.String())
+		w.WriteHeader(response.status)
+		w.Write([]byte(response.body))
+	}
+}
+
+// next returns the next response of the sequence attached to the request
+// path, and false if there is none.
+func (e *SequenceEndpoints) next(r *http.Request) (response sequenceResponse, ok bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	s, found := e.sequences[r.URL.Path]
+	if !found {
+		return
+	}
+
+	key := s.requestKey(r)
+	position := s.position(key)
+	if s.mode == sequenceModeCycle {
+		position = position % len(s.responses)
+	} else if position >= len(s.responses) {
+		return // exhausted
+	}
+	s.setPosition(key, position+1)
+	return s.responses[position], true
+}
+
+// position returns the position in the sequence of the key.
+func (s *sequence) position(key string) int {
+	if element, found := s.positions[key]; found {
+		return element.Value.(*sequencePosition).position
+	}
+	return 0
+}
+
+// setPosition sets the position in the sequence of the key. Beyond
+// sequenceMaxPositions, the least recently used key is forgotten (and starts
+// the sequence over).
+func (s *sequence) setPosition(key string, position int) {
+	element, found := s.positions[key]
+	if found {
+		s.lru.MoveToFront(element)
+	} else {
+		element = s.lru.PushFront(&sequencePosition{key: key})
+		s.positions[key] = element
+		if s.lru.Len() > sequenceMaxPositions {
+			delete(s.positions, s.lru.Remove(s.lru.Back()).(*sequencePosition).key)
+		}
+	}
+	element.Value.(*sequencePosition).position = position
+}
+
+// resetPositions forgets the positions of all the keys.
+func (s *sequence) resetPositions() {
+	s.positions = map[string]*list.Element{}
+	s.lru.Init()
+}
+
+// supports returns whether a route wrapped by the middleware serves the path.
+// The lock must be held by the caller.
+func (e *SequenceEndpoints) supports(path string) bool {
+	if e.routes[path] {
+		return true
+	}
+	for pattern := range e.routes {
+		if strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestKey returns the key used to track the position in the sequence.
+func (s *sequence) requestKey(r *http.Request) string {
+	switch s.key {
+	case sequenceKeyClient:
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	case sequenceKeyHeader:
+		return r.Header.Get(s.keyHeader)
+	default:
+		return ""
+	}
+}
+
+func (e *SequenceEndpoints) Configure(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	// do we have a POST method? (mandatory according to RFC)
+	if r.Method != http.MethodPost {
+		l.Warnf("only the POST method is allowed for posting forms, according to RFC 1867 (%s used)", r.Method)
+	}
+
+	// sequence config
+	s, err := parseSequenceFromFormData(l, r)
+	if err != nil {
+		errorString := "failed to parse sequence config"
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Warn(errorString)
+		return
+	}
+	if err = s.Validate(); err != nil {
+		errorString := "invalid sequence config"
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Warn(errorString)
+		return
+	}
+
+	// saving sequence (replaces any previous one)
+	e.lock.Lock()
+	if !e.supports(s.path) {
+		e.lock.Unlock()
+		errorString := fmt.Sprintf("sequences can't be attached to path %s, only to the endpoints answering requests and to paths under /mock/", s.path)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return
+	}
+	e.sequences[s.path] = s
+	e.lock.Unlock()
+	e.changed()
+
+	l.Infof("sequence of %d response(s) attached to path %s (mode: %s, key: %s)", len(s.responses), s.path, s.mode, s.key)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (e *SequenceEndpoints) Reset(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get(sequenceQueryParamPath)
+
+	defer e.changed() // once unlocked
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	// single sequence
+	if len(path) > 0 {
+		s, found := e.sequences[path]
+		if !found {
+			errorString := fmt.Sprintf("no sequence attached to path %s", path)
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+		s.resetPositions()
+		l.Infof("sequence attached to path %s has been reset", path)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// all sequences
+	for _, s := range e.sequences {
+		s.resetPositions()
+	}
+	l.Infof("%d sequence(s) have been reset", len(e.sequences))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (e *SequenceEndpoints) Delete(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get(sequenceQueryParamPath)
+
+	defer e.changed() // once unlocked
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	// single sequence
+	if len(path) > 0 {
+		if _, found := e.sequences[path]; !found {
+			errorString := fmt.Sprintf("no sequence attached to path %s", path)
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+		delete(e.sequences, path)
+		l.Infof("sequence attached to path %s has been deleted", path)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// all sequences
+	l.Infof("%d sequence(s) have been deleted", len(e.sequences))
+	e.sequences = map[string]*sequence{}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (e *SequenceEndpoints) Status(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+	if len(e.sequences) == 0 {
+		w.Write([]byte(">>>>> NO SEQUENCE CONFIGURED <<<<<"))
+		return
+	}
+
+	// sorting paths for a stable output
+	paths := make([]string, 0, len(e.sequences))
+	for path := range e.sequences {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		s := e.sequences[path]
+		key := s.key
+		if s.key == sequenceKeyHeader {
+			key += " " + s.keyHeader
+		}
+		w.Write([]byte(fmt.Sprintf("--- %s (mode: %s, key: %s)\n", path, s.mode, key)))
+		for i, response := range s.responses {
+			w.Write([]byte(fmt.Sprintf("%d: %s\n", i+1, response.String())))
+		}
+		keys := make([]string, 0, len(s.positions))
+		for key := range s.positions {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			w.Write([]byte(fmt.Sprintf("position for key %q: %d\n", key, s.position(key))))
+		}
+		w.Write([]byte("\n"))
+	}
+}
+
+// Mock is the default endpoint for paths under the mock prefix, it is only
+// reached when no sequence (or an exhausted one) is attached to the path.
+func (e *SequenceEndpoints) Mock(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	errorString := fmt.Sprintf("no sequence response available for path %s", r.URL.Path)
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte(errorString))
+	l.Warn(errorString)
+}
+
+func parseSequenceFromFormData(l *log.Entry, r *http.Request) (s *sequence, err error) {
+	l.Debug("parsing sequence configuration")
+	// parse form
+	if err = r.ParseMultipartForm(MaxFormSize); err != nil {
+		return
+	}
+
+	s = newSequence(
+		strings.TrimSpace(r.FormValue(sequenceFormDataPath)),
+		strings.TrimSpace(r.FormValue(sequenceFormDataMode)),
+		strings.TrimSpace(r.FormValue(sequenceFormDataKey)),
+		strings.TrimSpace(r.FormValue(sequenceFormDataKeyHeader)),
+	)
+	if len(s.mode) == 0 {
+		s.mode = sequenceModeSequence
+	}
+	if len(s.key) == 0 {
+		s.key = sequenceKeyNone
+	}
+
+	// responses (status,delay,body)
+	for i, responseString := range r.Form[sequenceFormDataResponse] {
+		parts := strings.SplitN(responseString, sequenceResponseSeparator, 3)
+		var response sequenceResponse
+		// status
+		statusString := strings.TrimSpace(parts[0])
+		if !statusCodeRegex.MatchString(statusString) {
+			return s, errors.Errorf("status code of response %d doesn't match regex: %s (value: %s)", i+1, statusCodeRegex.String(), statusString)
+		}
+		response.status, _ = strconv.Atoi(statusString) // can't fail thanks to the regexp
+		// delay
+		if len(parts) > 1 {
+			if delayString := strings.TrimSpace(parts[1]); len(delayString) > 0 {
+				if response.delay, err = time.ParseDuration(delayString); err != nil {
+					return s, errors.WithMessagef(err, "failed to parse delay of response %d to Golang duration", i+1)
+				}
+			}
+		}
+		// body
+		if len(parts) > 2 {
+			response.body = parts[2]
+		}
+		s.responses = append(s.responses, response)
+	}
+	return
+}
+
+func (s sequence) Validate() error {
+	// path
+	if len(s.path) == 0 {
+		return errors.New("sequence path not set")
+	}
+	if !strings.HasPrefix(s.path, "/") {
+		return errors.New("the sequence path must start with '/'")
+	}
+	// mode
+	switch s.mode {
+	case sequenceModeSequence, sequenceModeCycle:
+	default:
+		return errors.Errorf("unknown mode: %q, must be one of: %s, %s", s.mode, sequenceModeSequence, sequenceModeCycle)
+	}
+	// key
+	switch s.key {
+	case sequenceKeyNone, sequenceKeyClient:
+	case sequenceKeyHeader:
+		if len(s.keyHeader) == 0 {
+			return errors.Errorf("the key header must be set when using the %s key", sequenceKeyHeader)
+		}
+	default:
+		return errors.Errorf("unknown key: %q, must be one of: %s, %s, %s", s.key, sequenceKeyNone, sequenceKeyClient, sequenceKeyHeader)
+	}
+	// responses
+	if len(s.responses) == 0 {
+		return errors.New("no response defined in the sequence")
+	}
+	for i, response := range s.responses {
+		if response.delay < 0 {
+			return errors.Errorf("delay of response %d is inferior to zero (value: %s)", i+1, response.delay.String())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// RuntimeState is an overview of everything the instance has been told to do
+// at runtime (load, leaks, probes, crashes, mocks...).
+type RuntimeState struct {
+	Date       time.Time           `json:"date"`
+	CPU        CPUState            `json:"cpu"`
+	RAM        RAMState            `json:"ram"`
+	Disk       DiskState           `json:"disk"`
+	Leaks      LeakState           `json:"leaks"`
+	Monitoring MonitoringState     `json:"monitoring"`
+	Crashes    []CrashState        `json:"crashes"`
+	Sequences  []SequenceState     `json:"sequences"`
+	Databases  []DatabasePoolState `json:"databases"`
+	Hooks      []HookBucketState   `json:"hooks"`
+	Capture    CaptureState        `json:"capture"`
+}
+
+// PersistedState is the part of the runtime state saved to the state file,
+// and restored at startup.
+type PersistedState struct {
+	CPU        CPUState        `json:"cpu"`
+	RAM        RAMState        `json:"ram"`
+	Monitoring MonitoringState `json:"monitoring"`
+	Sequences  []SequenceState `json:"sequences"`
+}
+
+// stateNotifier is embedded by endpoints whose state is persisted, it is used
+// to notify each change. It must not be called with the endpoints lock held.
+type stateNotifier struct {
+	onChange func()
+}
+
+func (n *stateNotifier) OnChange(onChange func()) {
+	n.onChange = onChange
+}
+
+func (n *stateNotifier) changed() {
+	if n.onChange != nil {
+		n.onChange()
+	}
+}
+
+// stateSaveDelay is the delay between a change and the save of the state
+// file, so the changes made while answering requests (sequence positions,
+// probe failures, RAM leaks) don't write the file on the request path.
+const stateSaveDelay time.Duration = time.Second
+
+type StateEndpoints struct {
+	lock      *sync.Mutex
+	file      string // state file, persistence is disabled if empty
+	lastSaved []byte
+	saveTimer *time.Timer // pending save, nil if none
+
+	cpu        *CPUEndpoints
+	ram        *RAMEndpoints
+	disk       *DiskEndpoints
+	leaks      *LeakEndpoints
+	monitoring *MonitoringEndpoints
+	crash      *CrashEndpoints
+	sequences  *SequenceEndpoints
+	databases  *DatabaseEndpoints
+	hooks      *HooksEndpoints
+	capture    *CaptureEndpoints
+}
+
+func NewStateEndpoints(file string, cpu *CPUEndpoints, ram *RAMEndpoints, disk *DiskEndpoints, leaks *LeakEndpoints, monitoring *MonitoringEndpoints, crash *CrashEndpoints, sequences *SequenceEndpoints, databases *DatabaseEndpoints, hooks *HooksEndpoints, capture *CaptureEndpoints) *StateEndpoints {
+	e := &StateEndpoints{
+		lock:       &sync.Mutex{},
+		file:       file,
+		cpu:        cpu,
+		ram:        ram,
+		disk:       disk,
+		leaks:      leaks,
+		monitoring: monitoring,
+		crash:      crash,
+		sequences:  sequences,
+		databases:  databases,
+		hooks:      hooks,
+		capture:    capture,
+	}
+	if len(file) > 0 {
+		cpu.OnChange(e.scheduleSave)
+		ram.OnChange(e.scheduleSave)
+		monitoring.OnChange(e.scheduleSave)
+		sequences.OnChange(e.scheduleSave)
+		crash.OnExit(e.Flush)
+	}
+	return e
+}
+
+func (e *StateEndpoints) State() RuntimeState {
+	return RuntimeState{
+		Date:       time.Now(),
+		CPU:        e.cpu.State(),
+		RAM:        e.ram.State(),
+		Disk:       e.disk.State(),
+		Leaks:      e.leaks.State(),
+		Monitoring: e.monitoring.State(),
+		Crashes:    e.crash.State(),
+		Sequences:  e.sequences.State(),
+		Databases:  e.databases.State(),
+		Hooks:      e.hooks.State(),
+		Capture:    e.capture.State(),
+	}
+}
+
+func (e *StateEndpoints) Status(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	writeJSON(l, w, e.State())
+}
+
+func (e *StateEndpoints) persistedState() PersistedState {
+	ram := e.ram.State()
+	ram.Alloc = 0 // runtime statistic, not restored
+	return PersistedState{
+		CPU:        e.cpu.State(),
+		RAM:        ram,
+		Monitoring: e.monitoring.State(),
+		Sequences:  e.sequences.State(),
+	}
+}
+
+// scheduleSave saves the state after stateSaveDelay, unless a save is
+// already pending (which will include this change).
+func (e *StateEndpoints) scheduleSave() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.saveTimer != nil {
+		return
+	}
+	e.saveTimer = time.AfterFunc(stateSaveDelay, func() {
+		e.lock.Lock()
+		e.saveTimer = nil // changes made from now on schedule a new save
+		e.lock.Unlock()
+		e.save()
+	})
+}
+
+// Flush saves the pending changes right away, it must be called before
+// exiting since a scheduled save would be lost.
+func (e *StateEndpoints) Flush() {
+	if len(e.file) == 0 {
+		return
+	}
+	e.lock.Lock()
+	if e.saveTimer != nil {
+		e.saveTimer.Stop()
+		e.saveTimer = nil
+	}
+	e.lock.Unlock()
+	e.save() // no-op if nothing changed
+}
+
+// save writes the persisted state to the state file, if it changed since the
+// last save. The file is replaced atomically so it is never left half written.
+func (e *StateEndpoints) save() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	l := log.WithField("state_file", e.file)
+	content, err := json.MarshalIndent(e.persistedState(), "", "  ")
+	if err != nil {
+		l.WithError(err).Error("failed to marshal state to JSON")
+		return
+	}
+	if bytes.Equal(content, e.lastSaved) {
+		return
+	}
+	tempFile := e.file + ".tmp"
+	if err = os.WriteFile(tempFile, content, 0640); err != nil {
+		l.WithError(err).Error("failed to write state file")
+		return
+	}
+	if err = os.Rename(tempFile, e.file); err != nil {
+		l.WithError(err).Error("failed to replace state file")
+		return
+	}
+	e.lastSaved = content
+	l.Debug("state saved")
+}
+
+// Restore loads the state file, if any, and restores the state it describes.
+// The leaked RAM is only allocated again if restoreRAM is true, so an
+// instance killed for running out of memory doesn't run out again at startup.
+func (e *StateEndpoints) Restore(restoreRAM bool) error {
+	if len(e.file) == 0 {
+		return nil
+	}
+	l := log.WithField("state_file", e.file)
+	content, err := os.ReadFile(e.file)
+	if errors.Is(err, os.ErrNotExist) {
+		l.Info("no state file found, nothing to restore")
+		return nil
+	} else if err != nil {
+		return errors.WithMessagef(err, "failed to read state file %s", e.file)
+	}
+	var state PersistedState
+	if err = json.Unmarshal(content, &state); err != nil {
+		return errors.WithMessagef(err, "failed to parse state file %s", e.file)
+	}
+
+	if err = e.monitoring.Restore(state.Monitoring); err != nil {
+		return errors.WithMessage(err, "failed to restore monitoring state")
+	}
+	if err = e.sequences.Restore(state.Sequences); err != nil {
+		return errors.WithMessage(err, "failed to restore sequences")
+	}
+	if err = e.cpu.Restore(state.CPU); err != nil {
+		return errors.WithMessage(err, "failed to restore CPU load")
+	}
+	if !restoreRAM && state.RAM.Leaked > 0 {
+		l.Warnf("not restoring the %s of leaked RAM (set %s to restore it)", SizeToHumanReadable(float64(state.RAM.Leaked)), envStateRestoreRAM)
+		state.RAM.Leaked = 0
+	}
+	if err = e.ram.Restore(state.RAM); err != nil {
+		return errors.WithMessage(err, "failed to restore RAM leaks")
+	}
+	l.Infof("state restored: %d CPU load(s), %s leaked with %d leak worker(s), %d sequence(s)", len(state.CPU.Loads), SizeToHumanReadable(float64(state.RAM.Leaked)), len(state.RAM.LeakWorkers), len(state.Sequences))
+	e.save() // leak workers may have new IDs
+	return nil
+}
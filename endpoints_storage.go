@@ -0,0 +1,495 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// path values
+	storagePathValueKey string = "key"
+
+	// query params
+	storageQueryParamKey string = "key"
+
+	// prefix of the files being uploaded, keys can't start with a dot
+	storageTempFilePrefix string = ".upload-"
+)
+
+var (
+	storageKeyRegex *regexp.Regexp = regexp.MustCompile(`^[a-zA-Z0-9_-][a-zA-Z0-9._-]*(/[a-zA-Z0-9_-][a-zA-Z0-9._-]*)*$`)
+)
+
+// StoredFile describes a file of the storage.
+type StoredFile struct {
+	Key       string    `json:"key"`
+	Size      int64     `json:"size"`
+	SizeHuman string    `json:"size_human"`
+	Modified  time.Time `json:"modified"`
+	SHA256    string    `json:"sha256"`
+}
+
+// StorageEndpoints stores uploaded files in a folder, so they can be
+// downloaded later on (even after a restart if the folder is persistent).
+type StorageEndpoints struct {
+	lock   *sync.Mutex
+	folder string                 // storage is disabled if empty
+	hashes map[string]storageHash // by file path
+}
+
+// storageHash is the SHA-256 checksum of a stored file, valid as long as the
+// file size and modification date don't change.
+type storageHash struct {
+	size     int64
+	modified time.Time
+	sha256   string
+}
+
+func NewStorageEndpoints(folder string) *StorageEndpoints {
+	return &StorageEndpoints{
+		lock:   &sync.Mutex{},
+		folder: folder,
+		hashes: map[string]storageHash{},
+	}
+}
+
+// Upload stores the uploaded content if a key is given, otherwise it is
+// discarded. The upload bandwidth can be throttled.
+func (e *StorageEndpoints) Upload(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get(storageQueryParamKey)
+	if len(key) > 0 && len(e.folder) == 0 {
+		errorString := "storage is disabled, the " + envStorageFolder + " environment variable is not set"
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return
+	}
+	expected, format, ok := e.prepareUpload(l, w, r, key)
+	if !ok {
+		return
+	}
+	if len(key) == 0 {
+		upload(l, w, r, expected, format)
+		return
+	}
+	e.store(l, w, r, key, expected, format)
+}
+
+// File stores, retrieves or deletes a file depending on the request method.
+// Uploads and downloads can be throttled.
+func (e *StorageEndpoints) File(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue(storagePathValueKey)
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		throttle, ok := parseThrottle(l, w, r.URL.Query())
+		if !ok {
+			return
+		}
+		e.get(l, w, r, key, throttle)
+	case http.MethodPut, http.MethodPost:
+		expected, format, ok := e.prepareUpload(l, w, r, key)
+		if !ok {
+			return
+		}
+		e.store(l, w, r, key, expected, format)
+	case http.MethodDelete:
+		e.delete(l, w, key)
+	default:
+		errorString := fmt.Sprintf("method %s is not allowed", r.Method)
+		w.Header().Set("Allow", "GET, HEAD, PUT, POST, DELETE")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+	}
+}
+
+// List describes all the stored files, ordered by key.
+func (e *StorageEndpoints) List(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	format, ok := parseFormat(l, w, r.URL.Query())
+	if !ok {
+		return
+	}
+	files := []StoredFile{}
+	err := filepath.WalkDir(e.folder, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), storageTempFilePrefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		file, err := e.describe(path, info)
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		errorString := "failed to list stored files"
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Error(errorString)
+		return
+	}
+
+	l.Infof("%d stored file(s)", len(files))
+	if format == echoFormatJSON {
+		writeJSON(l, w, files)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	for _, file := range files {
+		w.Write([]byte(fmt.Sprintf("%s: %s (%d Bytes), modified: %s, sha256: %s\n", file.Key, file.SizeHuman, file.Size, file.Modified.Format(time.RFC3339), file.SHA256)))
+	}
+}
+
+// prepareUpload parses the upload query parameters and validates the key (if
+// any), then waits for the initial delay and throttles the request body. If
+// the parameters are invalid or the client leaves, the answer is written and
+// false is returned.
+func (e *StorageEndpoints) prepareUpload(l *log.Entry, w http.ResponseWriter, r *http.Request, key string) (expected []expectedChecksum, format string, ok bool) {
+	throttle, ok := parseThrottle(l, w, r.URL.Query())
+	if !ok {
+		return
+	}
+	if expected, format, ok = parseUploadQuery(l, w, r); !ok {
+		return
+	}
+	if len(key) > 0 {
+		if _, err := e.path(key); err != nil {
+			errorString := err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return nil, "", false
+		}
+	}
+
+	if err := throttle.Wait(r.Context()); err != nil {
+		l.WithError(err).Warn("upload canceled during the initial delay")
+		return nil, "", false
+	}
+	l.Debugf("upload bandwidth: %s", throttle)
+	r.Body = io.NopCloser(throttle.Reader(r.Context(), r.Body))
+	return expected, format, true
+}
+
+// store writes the request body to the file of the key. For multipart forms,
+// each uploaded file is stored under <key>/<filename>.
+func (e *StorageEndpoints) store(l *log.Entry, w http.ResponseWriter, r *http.Request, key string, expected []expectedChecksum, format string) {
+	// multipart form
+	reports := []UploadReport{}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			errorString := "failed to parse multipart form"
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString + ": " + err.Error()))
+			l.WithError(err).Warn(errorString)
+			return
+		}
+		for {
+			part, err := reader.NextPart()
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				errorString := "failed to parse multipart form"
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(errorString + ": " + err.Error()))
+				l.WithError(err).Warn(errorString)
+				return
+			}
+			if len(part.FileName()) == 0 {
+				continue // not a file
+			}
+			report, ok := e.storeFile(l, w, key+"/"+filepath.Base(part.FileName()), part, -1, expected)
+			if !ok {
+				return
+			}
+			reports = append(reports, report)
+		}
+		if len(reports) == 0 {
+			errorString := "no file found in the multipart form"
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorString))
+			l.Warn(errorString)
+			return
+		}
+	} else {
+		report, ok := e.storeFile(l, w, key, r.Body, r.ContentLength, expected)
+		if !ok {
+			return
+		}
+		reports = append(reports, report)
+	}
+
+	// answer
+	status := uploadStatus(reports...)
+	if format == echoFormatJSON {
+		if mediaType == "multipart/form-data" {
+			writeJSONStatus(l, w, status, reports)
+		} else {
+			writeJSONStatus(l, w, status, reports[0])
+		}
+		return
+	}
+	w.WriteHeader(status)
+	for i, report := range reports {
+		if i > 0 {
+			w.Write([]byte("\n"))
+		}
+		w.Write([]byte(report.String()))
+	}
+}
+
+// storeFile writes the content to the file of the key, replacing it
+// atomically. The file is not kept if it doesn't match the expected
+// checksums. If the file can't be stored, the answer is written and false is
+// returned.
+func (e *StorageEndpoints) storeFile(l *log.Entry, w http.ResponseWriter, key string, content io.Reader, contentLength int64, expected []expectedChecksum) (report UploadReport, ok bool) {
+	path, err := e.path(key)
+	if err != nil {
+		errorString := err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		errorString := fmt.Sprintf("key %s is already used by a folder", key)
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return
+	}
+
+	// uploading to a temporary file
+	l.Infof("start uploading file %s", key)
+	if err = os.MkdirAll(filepath.Dir(path), 0750); errors.Is(err, syscall.ENOTDIR) {
+		errorString := fmt.Sprintf("key %s is inside a file", key)
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return
+	} else if err != nil {
+		errorString := "failed to create storage folder"
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Error(errorString)
+		return
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), storageTempFilePrefix+"*")
+	if err != nil {
+		errorString := "failed to create file"
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Error(errorString)
+		return
+	}
+	defer os.Remove(file.Name()) // no-op once renamed
+	defer file.Close()
+	report, err = receiveUpload(content, contentLength, expected, file)
+	report.Key = key
+	if err != nil {
+		errorString := fmt.Sprintf("failed to upload file %s, received %d Bytes out of %d (content length)", key, report.Size, contentLength)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Error(errorString)
+		return
+	}
+	if err = file.Sync(); err != nil {
+		errorString := "failed to sync file to the storage"
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Error(errorString)
+		return
+	}
+	if !report.Verified {
+		l.Warnf("file %s doesn't match the expected checksums, it is not stored", key)
+		return report, true
+	}
+
+	// replacing the file
+	if err = os.Rename(file.Name(), path); err != nil {
+		errorString := "failed to store file"
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Error(errorString)
+		return
+	}
+	report.Stored = true
+	if info, err := os.Stat(path); err == nil {
+		e.lock.Lock()
+		e.hashes[path] = storageHash{size: info.Size(), modified: info.ModTime(), sha256: report.Checksums[checksumSHA256]}
+		e.lock.Unlock()
+	}
+	l.Infof("file %s stored, %s (%d Bytes)", key, report.SizeHuman, report.Size)
+	return report, true
+}
+
+// get answers with the content of the file of the key, supporting range and
+// conditional requests.
+func (e *StorageEndpoints) get(l *log.Entry, w http.ResponseWriter, r *http.Request, key string, throttle Throttle) {
+	path, err := e.path(key)
+	if err != nil {
+		errorString := err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		errorString := fmt.Sprintf("file %s not found", key)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return
+	} else if err != nil {
+		errorString := "failed to open file"
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Error(errorString)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		errorString := "failed to open file"
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Error(errorString)
+		return
+	} else if info.IsDir() {
+		errorString := fmt.Sprintf("file %s not found", key)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return
+	}
+	storedFile, err := e.describe(path, info)
+	if err != nil {
+		errorString := "failed to compute file checksum"
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Error(errorString)
+		return
+	}
+
+	sum, _ := hex.DecodeString(storedFile.SHA256) // can't fail, computed by the server
+	w.Header().Del("Content-Type")                // guessed from the file extension or content
+	w.Header().Set("ETag", `"`+storedFile.SHA256+`"`)
+	w.Header().Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum)+":")
+	if err = throttle.Wait(r.Context()); err != nil {
+		l.WithError(err).Warn("download canceled during the initial delay")
+		return
+	}
+	l.Infof("serving file %s, %s (%d Bytes), bandwidth: %s", key, storedFile.SizeHuman, storedFile.Size, throttle)
+	http.ServeContent(throttle.ResponseWriter(r.Context(), w), r, path, info.ModTime(), file)
+}
+
+// delete removes the file of the key, and its parent folders if they are
+// empty.
+func (e *StorageEndpoints) delete(l *log.Entry, w http.ResponseWriter, key string) {
+	path, err := e.path(key)
+	if err != nil {
+		errorString := err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return
+	}
+	if info, err := os.Stat(path); errors.Is(err, os.ErrNotExist) || (err == nil && info.IsDir()) {
+		errorString := fmt.Sprintf("file %s not found", key)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return
+	}
+	if err = os.Remove(path); err != nil {
+		errorString := "failed to delete file"
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Error(errorString)
+		return
+	}
+	e.lock.Lock()
+	delete(e.hashes, path)
+	e.lock.Unlock()
+	for folder := filepath.Dir(path); folder != e.folder; folder = filepath.Dir(folder) {
+		if os.Remove(folder) != nil {
+			break // not empty
+		}
+	}
+
+	answerText := fmt.Sprintf("file %s deleted", key)
+	l.Info(answerText)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(answerText))
+}
+
+// path returns the path of the file of the key.
+func (e *StorageEndpoints) path(key string) (string, error) {
+	if !storageKeyRegex.MatchString(key) {
+		return "", errors.Errorf("key %q doesn't match regex: %s", key, storageKeyRegex.String())
+	}
+	return filepath.Join(e.folder, filepath.FromSlash(key)), nil
+}
+
+// describe describes the stored file, computing its checksum if it is not
+// cached.
+func (e *StorageEndpoints) describe(path string, info fs.FileInfo) (file StoredFile, err error) {
+	key, err := filepath.Rel(e.folder, path)
+	if err != nil {
+		return
+	}
+	file = StoredFile{
+		Key:       filepath.ToSlash(key),
+		Size:      info.Size(),
+		SizeHuman: SizeToHumanReadable(float64(info.Size())),
+		Modified:  info.ModTime(),
+	}
+
+	e.lock.Lock()
+	cached, found := e.hashes[path]
+	e.lock.Unlock()
+	if found && cached.size == info.Size() && cached.modified.Equal(info.ModTime()) {
+		file.SHA256 = cached.sha256
+		return
+	}
+	content, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer content.Close()
+	hash := sha256.New()
+	if _, err = io.Copy(hash, content); err != nil {
+		return
+	}
+	file.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	e.lock.Lock()
+	e.hashes[path] = storageHash{size: info.Size(), modified: info.ModTime(), sha256: file.SHA256}
+	e.lock.Unlock()
+	return
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestParseWeightedStatusCodes(t *testing.T) {
+	tests := []struct {
+		name    string
+		codes   string
+		want    weightedStatusCodes
+		wantErr bool
+	}{
+		{name: "single code", codes: "503", want: weightedStatusCodes{{code: 503, weight: 1}}},
+		{name: "weighted codes", codes: "200:90, 503:8,5xx:2", want: weightedStatusCodes{{code: 200, weight: 90}, {code: 503, weight: 8}, {class: 5, weight: 2}}},
+		{name: "upper case class", codes: "4XX", want: weightedStatusCodes{{class: 4, weight: 1}}},
+		{name: "zero weight", codes: "200:0,500", want: weightedStatusCodes{{code: 200, weight: 0}, {code: 500, weight: 1}}},
+		{name: "max weight", codes: "200:1000000", want: weightedStatusCodes{{code: 200, weight: statusCodeMaxWeight}}},
+		{name: "empty", codes: "", wantErr: true},
+		{name: "empty code", codes: "200,", wantErr: true},
+		{name: "invalid code", codes: "600", wantErr: true},
+		{name: "invalid class", codes: "1xx", wantErr: true},
+		{name: "informational code", codes: "100", wantErr: true},
+		{name: "negative weight", codes: "200:-1", wantErr: true},
+		{name: "weight too big", codes: "200:1000001", wantErr: true},
+		{name: "weight overflow", codes: "200:99999999999999999999", wantErr: true},
+		{name: "all weights to zero", codes: "200:0,500:0", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			codes, err := parseWeightedStatusCodes(test.codes)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got: %+v", codes)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(codes, test.want) {
+				t.Errorf("got %+v, want %+v", codes, test.want)
+			}
+		})
+	}
+}
+
+func TestWeightedStatusCodesPick(t *testing.T) {
+	tests := []struct {
+		name  string
+		codes string
+		valid func(status int) bool
+	}{
+		{name: "single code", codes: "418", valid: func(status int) bool { return status == 418 }},
+		{name: "zero weight is never picked", codes: "200:0,500", valid: func(status int) bool { return status == 500 }},
+		{name: "class is replaced by a known code", codes: "5xx", valid: func(status int) bool {
+			return status >= 500 && status < 600 && len(http.StatusText(status)) > 0
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			codes, err := parseWeightedStatusCodes(test.codes)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for range 1000 {
+				if status := codes.pick(); !test.valid(status) {
+					t.Fatalf("unexpected status code picked: %d", status)
+				}
+			}
+		})
+	}
+}
+
+func TestDripInvalidChunkSize(t *testing.T) {
+	l := log.NewEntry(log.StandardLogger())
+	for _, chunkSize := range []string{"0", "00", "abc", "1048577"} {
+		t.Run(chunkSize, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			drip(l, w, httptest.NewRequest("GET", "/drip?size=10&duration=0s&chunk_size="+chunkSize, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestEchoStreamInvalidChunkSize(t *testing.T) {
+	l := log.NewEntry(log.StandardLogger())
+	for _, chunkSize := range []string{"0", "00", "abc", "1048577"} {
+		t.Run(chunkSize, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			echoStream(l, w, httptest.NewRequest("POST", "/echo/stream?chunk_size="+chunkSize, strings.NewReader("body")))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestSequencePositionsEviction(t *testing.T) {
+	s := newSequence("/test", sequenceModeSequence, sequenceKeyHeader, "X-Key")
+	for i := range sequenceMaxPositions {
+		s.setPosition(strconv.Itoa(i), 1)
+	}
+	s.setPosition("0", 2) // most recently used, key "1" is now the least recently used
+	s.setPosition("new", 1)
+	if len(s.positions) != sequenceMaxPositions || s.lru.Len() != sequenceMaxPositions {
+		t.Fatalf("got %d positions (%d in the LRU list), want %d", len(s.positions), s.lru.Len(), sequenceMaxPositions)
+	}
+	if position := s.position("1"); position != 0 {
+		t.Errorf("got position %d for the evicted key, want 0", position)
+	}
+	if position := s.position("0"); position != 2 {
+		t.Errorf("got position %d for the recently used key, want 2", position)
+	}
+	if position := s.position("new"); position != 1 {
+		t.Errorf("got position %d for the new key, want 1", position)
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// TLS extensions used by fingerprints
+	tlsExtensionServerName        uint16 = 0x0000
+	tlsExtensionALPN              uint16 = 0x0010
+	tlsExtensionSupportedVersions uint16 = 0x002b
+)
+
+var (
+	// names of common TLS extensions (IANA registry)
+	tlsExtensionNames map[uint16]string = map[uint16]string{
+		0x0000: "server_name",
+		0x0005: "status_request",
+		0x000a: "supported_groups",
+		0x000b: "ec_point_formats",
+		0x000d: "signature_algorithms",
+		0x0010: "application_layer_protocol_negotiation",
+		0x0012: "signed_certificate_timestamp",
+		0x0015: "padding",
+		0x0016: "encrypt_then_mac",
+		0x0017: "extended_master_secret",
+		0x001b: "compress_certificate",
+		0x0023: "session_ticket",
+		0x0029: "pre_shared_key",
+		0x002a: "early_data",
+		0x002b: "supported_versions",
+		0x002d: "psk_key_exchange_modes",
+		0x0031: "post_handshake_auth",
+		0x0033: "key_share",
+		0x4469: "application_settings",
+		0xfe0d: "encrypted_client_hello",
+		0xff01: "renegotiation_info",
+	}
+)
+
+// ClientHello describes the TLS ClientHello sent by a client, with its JA3
+// and JA4 fingerprints.
+type ClientHello struct {
+	ServerName        string         `json:"server_name"`
+	SupportedVersions []TLSParameter `json:"supported_versions"`
+	CipherSuites      []TLSParameter `json:"cipher_suites"`
+	Extensions        []TLSParameter `json:"extensions"`
+	SupportedCurves   []TLSParameter `json:"supported_curves"`
+	SupportedPoints   []uint8        `json:"supported_points"`
+	SignatureSchemes  []TLSParameter `json:"signature_schemes"`
+	ALPN              []string       `json:"alpn"`
+	JA3               string         `json:"ja3"`
+	JA3Hash           string         `json:"ja3_hash"`
+	JA4               string         `json:"ja4"`
+}
+
+type TLSParameter struct {
+	ID   uint16 `json:"id"`
+	Name string `json:"name"`
+}
+
+// TLSEndpoints records the ClientHello of each TLS connection, using the
+// GetConfigForClient hook of the server TLS configuration.
+type TLSEndpoints struct {
+	lock   *sync.Mutex
+	hellos map[string]ClientHello // per client address
+}
+
+func NewTLSEndpoints() *TLSEndpoints {
+	return &TLSEndpoints{
+		lock:   &sync.Mutex{},
+		hellos: map[string]ClientHello{},
+	}
+}
+
+// GetConfigForClient records the ClientHello, the server configuration is
+// left unchanged.
+func (e *TLSEndpoints) GetConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	if hello.Conn == nil {
+		return nil, nil
+	}
+	clientHello := NewClientHello(hello)
+	e.lock.Lock()
+	e.hellos[hello.Conn.RemoteAddr().String()] = clientHello
+	e.lock.Unlock()
+	return nil, nil
+}
+
+// ConnState forgets the ClientHello of closed connections, to be used as the
+// http.Server ConnState hook.
+func (e *TLSEndpoints) ConnState(conn net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateClosed, http.StateHijacked:
+		e.lock.Lock()
+		delete(e.hellos, conn.RemoteAddr().String())
+		e.lock.Unlock()
+	}
+}
+
+func (e *TLSEndpoints) Echo(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	l.Debug("parsing query variables")
+	format, ok := parseFormat(l, w, r.URL.Query())
+	if !ok {
+		return
+	}
+
+	// client hello
+	if r.TLS == nil {
+		errorString := "the request was not received over TLS"
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return
+	}
+	e.lock.Lock()
+	clientHello, found := e.hellos[r.RemoteAddr]
+	e.lock.Unlock()
+	if !found {
+		errorString := "no ClientHello recorded for the connection"
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(errorString))
+		l.Error(errorString)
+		return
+	}
+	l.Infof("client TLS fingerprint, JA3: %s, JA4: %s", clientHello.JA3Hash, clientHello.JA4)
+
+	// answer
+	if format == echoFormatJSON {
+		writeJSON(l, w, clientHello)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(clientHello.String()))
+}
+
+func (c ClientHello) String() string {
+	names := func(parameters []TLSParameter) string {
+		parameterNames := make([]string, 0, len(parameters))
+		for _, parameter := range parameters {
+			parameterNames = append(parameterNames, parameter.Name)
+		}
+		return strings.Join(parameterNames, ", ")
+	}
+	points := make([]string, 0, len(c.SupportedPoints))
+	for _, point := range c.SupportedPoints {
+		points = append(points, strconv.Itoa(int(point)))
+	}
+
+	var b strings.Builder
+	b.WriteString("--- TLS CLIENT HELLO\n")
+	b.WriteString("Server name: " + c.ServerName + "\n")
+	b.WriteString("Supported versions: " + names(c.SupportedVersions) + "\n")
+	b.WriteString("Cipher suites: " + names(c.CipherSuites) + "\n")
+	b.WriteString("Extensions: " + names(c.Extensions) + "\n")
+	b.WriteString("Supported curves: " + names(c.SupportedCurves) + "\n")
+	b.WriteString("Supported points: " + strings.Join(points, ", ") + "\n")
+	b.WriteString("Signature schemes: " + names(c.SignatureSchemes) + "\n")
+	b.WriteString("ALPN: " + strings.Join(c.ALPN, ", ") + "\n")
+	b.WriteString("\n--- FINGERPRINTS\n")
+	b.WriteString("JA3: " + c.JA3 + "\n")
+	b.WriteString("JA3 hash: " + c.JA3Hash + "\n")
+	b.WriteString("JA4: " + c.JA4 + "\n")
+	return b.String()
+}
+
+func NewClientHello(hello *tls.ClientHelloInfo) ClientHello {
+	c := ClientHello{
+		ServerName:      hello.ServerName,
+		SupportedPoints: hello.SupportedPoints,
+		ALPN:            hello.SupportedProtos,
+	}
+	for _, version := range hello.SupportedVersions {
+		c.SupportedVersions = append(c.SupportedVersions, TLSParameter{ID: version, Name: tlsParameterName(version, tls.VersionName(version))})
+	}
+	for _, cipherSuite := range hello.CipherSuites {
+		c.CipherSuites = append(c.CipherSuites, TLSParameter{ID: cipherSuite, Name: tlsParameterName(cipherSuite, tls.CipherSuiteName(cipherSuite))})
+	}
+	for _, extension := range hello.Extensions {
+		name, found := tlsExtensionNames[extension]
+		if !found {
+			name = fmt.Sprintf("0x%04X", extension)
+		}
+		c.Extensions = append(c.Extensions, TLSParameter{ID: extension, Name: tlsParameterName(extension, name)})
+	}
+	for _, curve := range hello.SupportedCurves {
+		c.SupportedCurves = append(c.SupportedCurves, TLSParameter{ID: uint16(curve), Name: tlsParameterName(uint16(curve), curve.String())})
+	}
+	for _, scheme := range hello.SignatureSchemes {
+		c.SignatureSchemes = append(c.SignatureSchemes, TLSParameter{ID: uint16(scheme), Name: tlsParameterName(uint16(scheme), scheme.String())})
+	}
+	c.JA3 = c.ja3()
+	ja3Hash := md5.Sum([]byte(c.JA3))
+	c.JA3Hash = hex.EncodeToString(ja3Hash[:])
+	c.JA4 = c.ja4()
+	return c
+}
+
+// ja3 computes the JA3 fingerprint: version,ciphers,extensions,curves,points
+// (GREASE values excluded). The ClientHello legacy version is not exposed by
+// Golang, it is deduced from the supported versions.
+func (c ClientHello) ja3() string {
+	version := tls.VersionTLS12
+	if !slices.ContainsFunc(c.Extensions, func(p TLSParameter) bool { return p.ID == tlsExtensionSupportedVersions }) {
+		version = int(c.maxVersion())
+	}
+	points := make([]string, 0, len(c.SupportedPoints))
+	for _, point := range c.SupportedPoints {
+		points = append(points, strconv.Itoa(int(point)))
+	}
+	return strings.Join([]string{
+		strconv.Itoa(version),
+		tlsParametersJoin(c.CipherSuites, "-", false),
+		tlsParametersJoin(c.Extensions, "-", false),
+		tlsParametersJoin(c.SupportedCurves, "-", false),
+		strings.Join(points, "-"),
+	}, ",")
+}
+
+// ja4 computes the JA4 fingerprint (TCP only).
+// Specification: https://github.com/FoxIO-LLC/ja4/blob/main/technical_details/JA4.md
+func (c ClientHello) ja4() string {
+	// version
+	version := "00"
+	switch c.maxVersion() {
+	case tls.VersionTLS13:
+		version = "13"
+	case tls.VersionTLS12:
+		version = "12"
+	case tls.VersionTLS11:
+		version = "11"
+	case tls.VersionTLS10:
+		version = "10"
+	case 0x0300: // SSL 3.0
+		version = "s3"
+	}
+	// SNI
+	sni := "i"
+	if len(c.ServerName) > 0 {
+		sni = "d"
+	}
+	// ALPN
+	alpn := "00"
+	if len(c.ALPN) > 0 && len(c.ALPN[0]) > 0 {
+		alpn = c.ALPN[0][:1] + c.ALPN[0][len(c.ALPN[0])-1:]
+	}
+	ciphers := tlsParametersWithoutGREASE(c.CipherSuites)
+	extensions := tlsParametersWithoutGREASE(c.Extensions)
+	a := fmt.Sprintf("t%s%s%02d%02d%s", version, sni, min(len(ciphers), 99), min(len(extensions), 99), alpn)
+
+	// sorted ciphers
+	b := ja4Hash(tlsParametersJoin(tlsParametersSorted(ciphers), ",", true))
+
+	// sorted extensions (without SNI and ALPN) and signature schemes
+	extensions = slices.DeleteFunc(extensions, func(p TLSParameter) bool {
+		return p.ID == tlsExtensionServerName || p.ID == tlsExtensionALPN
+	})
+	cString := tlsParametersJoin(tlsParametersSorted(extensions), ",", true)
+	if schemes := tlsParametersJoin(c.SignatureSchemes, ",", true); len(schemes) > 0 {
+		cString += "_" + schemes
+	}
+	return a + "_" + b + "_" + ja4Hash(cString)
+}
+
+// maxVersion returns the highest supported version (GREASE excluded).
+func (c ClientHello) maxVersion() (version uint16) {
+	for _, v := range tlsParametersWithoutGREASE(c.SupportedVersions) {
+		version = max(version, v.ID)
+	}
+	return
+}
+
+// ja4Hash returns the first 12 characters of the SHA256 of the string.
+func ja4Hash(s string) string {
+	if len(s) == 0 {
+		return "000000000000"
+	}
+	hash := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(hash[:])[:12]
+}
+
+// isGREASE tells if the value is a GREASE one (RFC 8701), such values are
+// ignored by fingerprints.
+func isGREASE(value uint16) bool {
+	return value&0x0f0f == 0x0a0a && value>>8 == value&0xff
+}
+
+func tlsParameterName(id uint16, name string) string {
+	if isGREASE(id) {
+		return "GREASE"
+	}
+	return name
+}
+
+func tlsParametersWithoutGREASE(parameters []TLSParameter) []TLSParameter {
+	return slices.DeleteFunc(slices.Clone(parameters), func(p TLSParameter) bool { return isGREASE(p.ID) })
+}
+
+func tlsParametersSorted(parameters []TLSParameter) []TLSParameter {
+	sorted := slices.Clone(parameters)
+	slices.SortFunc(sorted, func(a, b TLSParameter) int { return int(a.ID) - int(b.ID) })
+	return sorted
+}
+
+// tlsParametersJoin joins the IDs of parameters (GREASE excluded), in decimal
+// or 4 characters hex.
+func tlsParametersJoin(parameters []TLSParameter, separator string, hexadecimal bool) string {
+	ids := []string{}
+	for _, parameter := range tlsParametersWithoutGREASE(parameters) {
+		if hexadecimal {
+			ids = append(ids, fmt.Sprintf("%04x", parameter.ID))
+		} else {
+			ids = append(ids, strconv.Itoa(int(parameter.ID)))
+		}
+	}
+	return strings.Join(ids, separator)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestClientHelloFingerprints(t *testing.T) {
+	// Chrome ClientHello, from the JA4 specification, with GREASE values
+	chrome := &tls.ClientHelloInfo{
+		ServerName:        "example.com",
+		SupportedVersions: []uint16{0x3a3a, tls.VersionTLS13, tls.VersionTLS12},
+		CipherSuites:      []uint16{0x1a1a, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9, 0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035},
+		Extensions:        []uint16{0x2a2a, 0x0000, 0x0017, 0xff01, 0x000a, 0x000b, 0x0023, 0x0010, 0x0005, 0x000d, 0x0012, 0x0033, 0x002d, 0x002b, 0x001b, 0x4469, 0x0015, 0x4a4a},
+		SupportedCurves:   []tls.CurveID{0x5a5a, tls.X25519, tls.CurveP256, tls.CurveP384},
+		SupportedPoints:   []uint8{0},
+		SignatureSchemes:  []tls.SignatureScheme{0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601},
+		SupportedProtos:   []string{"h2", "http/1.1"},
+	}
+
+	tests := []struct {
+		name    string
+		hello   *tls.ClientHelloInfo
+		ja3     string
+		ja3Hash string // only checked if set
+		ja4     string
+	}{
+		{
+			name:    "chrome",
+			hello:   chrome,
+			ja3:     "771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53,0-23-65281-10-11-35-16-5-13-18-51-45-43-27-17513-21,29-23-24,0",
+			ja3Hash: "",
+			ja4:     "t13d1516h2_8daaf6152771_e5627efa2ab1",
+		},
+		{
+			// TLS 1.0 ClientHello, from the JA3 specification
+			name: "TLS 1.0 without supported versions extension",
+			hello: &tls.ClientHelloInfo{
+				SupportedVersions: []uint16{tls.VersionTLS10},
+				CipherSuites:      []uint16{47, 53, 5, 10, 49161, 49162, 49171, 49172, 50, 56, 19, 4},
+				Extensions:        []uint16{0, 10, 11},
+				SupportedCurves:   []tls.CurveID{23, 24, 25},
+				SupportedPoints:   []uint8{0},
+			},
+			ja3:     "769,47-53-5-10-49161-49162-49171-49172-50-56-19-4,0-10-11,23-24-25,0",
+			ja3Hash: "ada70206e40642a3e4461f35503241d5",
+			ja4:     "t10i120300_" + ja4Hash("0004,0005,000a,0013,002f,0032,0035,0038,c009,c00a,c013,c014") + "_" + ja4Hash("000a,000b"),
+		},
+		{
+			name:    "empty",
+			hello:   &tls.ClientHelloInfo{},
+			ja3:     "0,,,,",
+			ja3Hash: "",
+			ja4:     "t00i000000_000000000000_000000000000",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := NewClientHello(test.hello)
+			if c.JA3 != test.ja3 {
+				t.Errorf("got JA3 %s, want %s", c.JA3, test.ja3)
+			}
+			if len(test.ja3Hash) > 0 && c.JA3Hash != test.ja3Hash {
+				t.Errorf("got JA3 hash %s, want %s", c.JA3Hash, test.ja3Hash)
+			}
+			if c.JA4 != test.ja4 {
+				t.Errorf("got JA4 %s, want %s", c.JA4, test.ja4)
+			}
+		})
+	}
+}
+
+func TestIsGREASE(t *testing.T) {
+	tests := []struct {
+		value uint16
+		want  bool
+	}{
+		{0x0a0a, true},
+		{0x1a1a, true},
+		{0xfafa, true},
+		{0x0a1a, false},
+		{0x1301, false},
+		{0x0000, false},
+	}
+	for _, test := range tests {
+		if got := isGREASE(test.value); got != test.want {
+			t.Errorf("isGREASE(0x%04x) = %t, want %t", test.value, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// checksum algorithms
+	checksumMD5    string = "md5"
+	checksumSHA1   string = "sha1"
+	checksumSHA256 string = "sha256"
+	checksumCRC32C string = "crc32c"
+)
+
+var (
+	// checksum algorithms, in the order they are reported
+	checksumAlgorithms = []string{checksumMD5, checksumSHA1, checksumSHA256, checksumCRC32C}
+
+	// algorithm names used by the Digest (RFC 3230), Content-Digest and
+	// Repr-Digest (RFC 9530) headers
+	digestHeaderAlgorithms = map[string]string{
+		"md5":     checksumMD5,
+		"sha":     checksumSHA1,
+		"sha-1":   checksumSHA1,
+		"sha-256": checksumSHA256,
+		"crc32c":  checksumCRC32C,
+	}
+
+	crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// UploadReport describes a received upload.
+type UploadReport struct {
+	Key           string                 `json:"key,omitempty"`
+	Stored        bool                   `json:"stored"`
+	Size          int64                  `json:"size"`
+	SizeHuman     string                 `json:"size_human"`
+	ContentLength int64                  `json:"content_length"`
+	Duration      string                 `json:"duration"`
+	Throughput    string                 `json:"throughput"`
+	Checksums     map[string]string      `json:"checksums"`
+	Verifications []ChecksumVerification `json:"verifications"`
+	Verified      bool                   `json:"verified"`
+}
+
+// ChecksumVerification is the comparison of a computed checksum with the one
+// expected by the client.
+type ChecksumVerification struct {
+	Algorithm string `json:"algorithm"`
+	Source    string `json:"source"`
+	Expected  string `json:"expected"`
+	Computed  string `json:"computed"`
+	Match     bool   `json:"match"`
+}
+
+// expectedChecksum is a checksum the client expects the upload to match.
+type expectedChecksum struct {
+	algorithm string
+	source    string
+	sum       []byte
+}
+
+// upload discards the request body, computing its checksums and verifying the
+// expected ones.
+func upload(l *log.Entry, w http.ResponseWriter, r *http.Request, expected []expectedChecksum, format string) {
+	l.Info("start uploading")
+	report, err := receiveUpload(r.Body, r.ContentLength, expected, io.Discard)
+	if err != nil {
+		l.WithError(err).Errorf("failed to read request body, received %d Bytes out of %d (content length)", report.Size, r.ContentLength)
+		return
+	}
+	l.Infof("upload done, %s sent (%d Bytes)", report.SizeHuman, report.Size)
+
+	status := uploadStatus(report)
+	if status != http.StatusOK {
+		l.Warn("upload doesn't match the expected checksums")
+	}
+	if format == echoFormatJSON {
+		writeJSONStatus(l, w, status, report)
+		return
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(report.String()))
+}
+
+// parseUploadQuery parses the expected checksums and the answer format. If
+// they are invalid, the answer is written and false is returned.
+func parseUploadQuery(l *log.Entry, w http.ResponseWriter, r *http.Request) (expected []expectedChecksum, format string, ok bool) {
+	l.Debug("parsing query variables")
+	// expected checksums
+	expected, err := parseExpectedChecksums(l, r)
+	if err != nil {
+		errorString := err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return
+	}
+	// format
+	if format, ok = parseFormat(l, w, r.URL.Query()); !ok {
+		return
+	}
+	return expected, format, true
+}
+
+// receiveUpload copies the body to dst, computing its checksums and verifying
+// the expected ones. On error, the report only contains the received size.
+func receiveUpload(body io.Reader, contentLength int64, expected []expectedChecksum, dst io.Writer) (report UploadReport, err error) {
+	startDate := time.Now()
+
+	// computing checksums while reading the body
+	hashes := map[string]hash.Hash{
+		checksumMD5:    md5.New(),
+		checksumSHA1:   sha1.New(),
+		checksumSHA256: sha256.New(),
+		checksumCRC32C: crc32.New(crc32cTable),
+	}
+	writers := []io.Writer{dst}
+	for _, algorithm := range checksumAlgorithms {
+		writers = append(writers, hashes[algorithm])
+	}
+	size, err := io.Copy(io.MultiWriter(writers...), body)
+	report.Size = size
+	if err != nil {
+		return
+	}
+	duration := time.Since(startDate)
+
+	// report
+	report = UploadReport{
+		Size:          size,
+		SizeHuman:     SizeToHumanReadable(float64(size)),
+		ContentLength: contentLength,
+		Duration:      duration.String(),
+		Throughput:    SizeToHumanReadable(float64(size)/max(duration.Seconds(), time.Microsecond.Seconds())) + "/s",
+		Checksums:     map[string]string{},
+		Verifications: []ChecksumVerification{},
+		Verified:      true,
+	}
+	for algorithm, checksum := range hashes {
+		report.Checksums[algorithm] = hex.EncodeToString(checksum.Sum(nil))
+	}
+	for _, checksum := range expected {
+		computed := hashes[checksum.algorithm].Sum(nil)
+		verification := ChecksumVerification{
+			Algorithm: checksum.algorithm,
+			Source:    checksum.source,
+			Expected:  hex.EncodeToString(checksum.sum),
+			Computed:  hex.EncodeToString(computed),
+			Match:     bytes.Equal(checksum.sum, computed),
+		}
+		report.Verified = report.Verified && verification.Match
+		report.Verifications = append(report.Verifications, verification)
+	}
+	return
+}
+
+// uploadStatus returns the answer status code of the uploads: 422 if one of
+// them doesn't match an expected checksum.
+func uploadStatus(reports ...UploadReport) int {
+	for _, report := range reports {
+		if !report.Verified {
+			return http.StatusUnprocessableEntity
+		}
+	}
+	return http.StatusOK
+}
+
+func (u UploadReport) String() string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "upload done, %s sent (%d Bytes)\n", u.SizeHuman, u.Size)
+	if u.Stored {
+		fmt.Fprintf(&text, "stored as: %s\n", u.Key)
+	} else if len(u.Key) > 0 {
+		fmt.Fprintf(&text, "NOT stored as: %s (checksum mismatch)\n", u.Key)
+	}
+	switch {
+	case u.ContentLength < 0:
+		text.WriteString("content length: unknown\n")
+	case u.ContentLength == u.Size:
+		fmt.Fprintf(&text, "content length: %d Bytes (matches received size)\n", u.ContentLength)
+	default:
+		fmt.Fprintf(&text, "content length: %d Bytes (DOES NOT match received size)\n", u.ContentLength)
+	}
+	fmt.Fprintf(&text, "duration: %s (%s)\n", u.Duration, u.Throughput)
+	for _, algorithm := range checksumAlgorithms {
+		fmt.Fprintf(&text, "%s: %s\n", algorithm, u.Checksums[algorithm])
+	}
+	for _, verification := range u.Verifications {
+		if verification.Match {
+			fmt.Fprintf(&text, "%s verification (%s): ok\n", verification.Algorithm, verification.Source)
+		} else {
+			fmt.Fprintf(&text, "%s verification (%s): MISMATCH, expected %s\n", verification.Algorithm, verification.Source, verification.Expected)
+		}
+	}
+	return text.String()
+}
+
+// parseExpectedChecksums returns the checksums expected by the client, from
+// the query parameters (hexadecimal) and the Content-MD5, Digest,
+// Content-Digest and Repr-Digest headers (base64).
+func parseExpectedChecksums(l *log.Entry, r *http.Request) (expected []expectedChecksum, err error) {
+	// query parameters
+	query := r.URL.Query()
+	for _, algorithm := range checksumAlgorithms {
+		value := query.Get(algorithm)
+		if len(value) == 0 {
+			continue
+		}
+		sum, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "%s checksum is not a valid hexadecimal string", algorithm)
+		}
+		expected = append(expected, expectedChecksum{algorithm: algorithm, source: "query", sum: sum})
+	}
+
+	// Content-MD5 header
+	if value := r.Header.Get("Content-MD5"); len(value) > 0 {
+		sum, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, errors.WithMessage(err, "Content-MD5 header is not a valid base64 string")
+		}
+		expected = append(expected, expectedChecksum{algorithm: checksumMD5, source: "header Content-MD5", sum: sum})
+	}
+
+	// digest headers, formatted as: <algorithm>=<base64>, or <algorithm>=:<base64>: for RFC 9530
+	for _, header := range []string{"Digest", "Content-Digest", "Repr-Digest"} {
+		for _, value := range r.Header.Values(header) {
+			for _, digest := range strings.Split(value, ",") {
+				name, encoded, found := strings.Cut(strings.TrimSpace(digest), "=")
+				if !found {
+					return nil, errors.Errorf("%s header is malformed: %q", header, digest)
+				}
+				algorithm, known := digestHeaderAlgorithms[strings.ToLower(name)]
+				if !known {
+					l.Debugf("ignoring unsupported %s algorithm: %s", header, name)
+					continue
+				}
+				sum, err := base64.StdEncoding.DecodeString(strings.Trim(encoded, ":"))
+				if err != nil {
+					return nil, errors.WithMessagef(err, "%s header %s value is not a valid base64 string", header, name)
+				}
+				expected = append(expected, expectedChecksum{algorithm: algorithm, source: "header " + header, sum: sum})
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestParseExpectedChecksums(t *testing.T) {
+	// checksums of "hello"
+	md5Sum, _ := hex.DecodeString("5d41402abc4b2a76b9719d911017c592")
+	sha256Sum, _ := hex.DecodeString("2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824")
+	const (
+		md5Base64    string = "XUFAKrxLKna5cZ2REBfFkg=="
+		sha256Base64 string = "LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="
+	)
+
+	tests := []struct {
+		name    string
+		query   string
+		headers map[string][]string
+		want    []expectedChecksum
+		wantErr bool
+	}{
+		{name: "none", want: nil},
+		{name: "query parameters", query: "?sha256=2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824&md5=5d41402abc4b2a76b9719d911017c592", want: []expectedChecksum{
+			{algorithm: checksumMD5, source: "query", sum: md5Sum},
+			{algorithm: checksumSHA256, source: "query", sum: sha256Sum},
+		}},
+		{name: "invalid hexadecimal query parameter", query: "?md5=xyz", wantErr: true},
+		{name: "Content-MD5 header", headers: map[string][]string{"Content-MD5": {md5Base64}}, want: []expectedChecksum{
+			{algorithm: checksumMD5, source: "header Content-MD5", sum: md5Sum},
+		}},
+		{name: "invalid Content-MD5 header", headers: map[string][]string{"Content-MD5": {"!!!"}}, wantErr: true},
+		{name: "Digest header", headers: map[string][]string{"Digest": {"MD5=" + md5Base64 + ", SHA-256=" + sha256Base64}}, want: []expectedChecksum{
+			{algorithm: checksumMD5, source: "header Digest", sum: md5Sum},
+			{algorithm: checksumSHA256, source: "header Digest", sum: sha256Sum},
+		}},
+		{name: "RFC 9530 headers", headers: map[string][]string{"Content-Digest": {"sha-256=:" + sha256Base64 + ":"}, "Repr-Digest": {"md5=:" + md5Base64 + ":"}}, want: []expectedChecksum{
+			{algorithm: checksumSHA256, source: "header Content-Digest", sum: sha256Sum},
+			{algorithm: checksumMD5, source: "header Repr-Digest", sum: md5Sum},
+		}},
+		{name: "unsupported digest algorithm is ignored", headers: map[string][]string{"Content-Digest": {"sha-512=:AAAA:, sha-256=:" + sha256Base64 + ":"}}, want: []expectedChecksum{
+			{algorithm: checksumSHA256, source: "header Content-Digest", sum: sha256Sum},
+		}},
+		{name: "malformed digest header", headers: map[string][]string{"Digest": {"sha-256"}}, wantErr: true},
+		{name: "invalid base64 digest header", headers: map[string][]string{"Digest": {"sha-256=!!!"}}, wantErr: true},
+	}
+	l := log.NewEntry(log.StandardLogger())
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("PUT", "/upload"+test.query, nil)
+			for header, values := range test.headers {
+				for _, value := range values {
+					r.Header.Add(header, value)
+				}
+			}
+			expected, err := parseExpectedChecksums(l, r)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got: %+v", expected)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(expected, test.want) {
+				t.Errorf("got %+v, want %+v", expected, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// kubernetes downward API environment variables
+	envPodName           string = "POD_NAME"
+	envPodNamespace      string = "POD_NAMESPACE"
+	envPodIP             string = "POD_IP"
+	envNodeName          string = "NODE_NAME"
+	envPodServiceAccount string = "POD_SERVICE_ACCOUNT"
+
+	// mounted by kubernetes in the pods using a service account
+	kubernetesNamespaceFile string = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+var (
+	// injected at build time, i.e.: go build -ldflags "-X main.Version=1.0.0 -X main.Commit=$(git rev-parse HEAD)"
+	Version string = "dev"
+	Commit  string
+
+	startDate = time.Now()
+)
+
+// WhoamiState describes the instance which answered the request.
+type WhoamiState struct {
+	Hostname   string            `json:"hostname"`
+	InstanceID string            `json:"instance_id"`
+	Interfaces []WhoamiInterface `json:"interfaces"`
+	Kubernetes *WhoamiKubernetes `json:"kubernetes,omitempty"` // nil if not running in kubernetes
+	Version    string            `json:"version"`
+	Commit     string            `json:"commit"`
+	GoVersion  string            `json:"go_version"`
+	StartDate  time.Time         `json:"start_date"`
+	Uptime     string            `json:"uptime"`
+	GOMAXPROCS int               `json:"gomaxprocs"`
+	NumCPU     int               `json:"num_cpu"`
+}
+
+// WhoamiInterface describes a network interface and its IPs.
+type WhoamiInterface struct {
+	Name string   `json:"name"`
+	IPs  []string `json:"ips"`
+}
+
+// WhoamiKubernetes describes the pod, from the downward API environment
+// variables.
+type WhoamiKubernetes struct {
+	Pod            string `json:"pod,omitempty"`
+	Namespace      string `json:"namespace,omitempty"`
+	PodIP          string `json:"pod_ip,omitempty"`
+	Node           string `json:"node,omitempty"`
+	ServiceAccount string `json:"service_account,omitempty"`
+}
+
+func whoami(l *log.Entry, w http.ResponseWriter, r *http.Request) {
+	format, ok := parseFormat(l, w, r.URL.Query())
+	if !ok {
+		return
+	}
+	state, err := NewWhoamiState()
+	if err != nil {
+		errorString := "failed to list network interfaces"
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(errorString + ": " + err.Error()))
+		l.WithError(err).Error(errorString)
+		return
+	}
+
+	if format == echoFormatJSON {
+		writeJSON(l, w, state)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("hostname: " + state.Hostname + "\n"))
+	w.Write([]byte("instance ID: " + state.InstanceID + "\n"))
+	for _, iface := range state.Interfaces {
+		w.Write([]byte("interface " + iface.Name + ": " + strings.Join(iface.IPs, ", ") + "\n"))
+	}
+	if k := state.Kubernetes; k != nil {
+		w.Write([]byte("pod: " + k.Pod + "\n"))
+		w.Write([]byte("namespace: " + k.Namespace + "\n"))
+		w.Write([]byte("pod IP: " + k.PodIP + "\n"))
+		w.Write([]byte("node: " + k.Node + "\n"))
+		w.Write([]byte("service account: " + k.ServiceAccount + "\n"))
+	}
+	w.Write([]byte("version: " + state.Version + "\n"))
+	w.Write([]byte("commit: " + state.Commit + "\n"))
+	w.Write([]byte("go version: " + state.GoVersion + "\n"))
+	w.Write([]byte("start date: " + state.StartDate.Format(time.RFC3339) + "\n"))
+	w.Write([]byte("uptime: " + state.Uptime + "\n"))
+	w.Write([]byte("GOMAXPROCS: " + strconv.Itoa(state.GOMAXPROCS) + "\n"))
+	w.Write([]byte("CPUs: " + strconv.Itoa(state.NumCPU) + "\n"))
+}
+
+// NewWhoamiState describes the running instance. An error is returned if
+// the network interfaces can't be listed.
+func NewWhoamiState() (s WhoamiState, err error) {
+	s = WhoamiState{
+		InstanceID: InstanceID,
+		Interfaces: []WhoamiInterface{},
+		Version:    Version,
+		Commit:     Commit,
+		GoVersion:  runtime.Version(),
+		StartDate:  startDate,
+		Uptime:     time.Since(startDate).Round(time.Second).String(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		NumCPU:     runtime.NumCPU(),
+	}
+	if s.Hostname, err = os.Hostname(); err != nil {
+		log.WithError(err).Warn("failed to get hostname")
+	}
+	// commit from the VCS information, if not injected
+	if buildInfo, found := debug.ReadBuildInfo(); found && len(s.Commit) == 0 {
+		for _, setting := range buildInfo.Settings {
+			if setting.Key == "vcs.revision" {
+				s.Commit = setting.Value
+			}
+		}
+	}
+
+	// network interfaces
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return s, err
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return s, err
+		}
+		whoamiIface := WhoamiInterface{Name: iface.Name, IPs: []string{}}
+		for _, addr := range addrs {
+			if ipNet, isIPNet := addr.(*net.IPNet); isIPNet {
+				whoamiIface.IPs = append(whoamiIface.IPs, ipNet.IP.String())
+			}
+		}
+		s.Interfaces = append(s.Interfaces, whoamiIface)
+	}
+
+	// kubernetes
+	k := WhoamiKubernetes{}
+	k.Pod, _ = syscall.Getenv(envPodName)
+	k.Namespace, _ = syscall.Getenv(envPodNamespace)
+	k.PodIP, _ = syscall.Getenv(envPodIP)
+	k.Node, _ = syscall.Getenv(envNodeName)
+	k.ServiceAccount, _ = syscall.Getenv(envPodServiceAccount)
+	if len(k.Namespace) == 0 {
+		if namespace, err := os.ReadFile(kubernetesNamespaceFile); err == nil {
+			k.Namespace = strings.TrimSpace(string(namespace))
+		}
+	}
+	if k != (WhoamiKubernetes{}) {
+		s.Kubernetes = &k
+	}
+	return s, nil
+}
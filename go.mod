@@ -1,6 +1,6 @@
 module github.com/kanshiroron/integration-tester-webserver
 
-go 1.22.2
+go 1.24
 
 require (
 	github.com/go-sql-driver/mysql v1.8.1
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HAR (HTTP Archive) format, version 1.2.
+// Specification: http://www.softwareishard.com/blog/har-12-spec/
+
+const (
+	harVersion     string = "1.2"
+	harCreatorName string = "Integration Toolbox WebServer"
+)
+
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HAREntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type HARCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type HARContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type HARTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// NewHAR creates an HTTP archive containing the given entries.
+func NewHAR(entries []HAREntry) HAR {
+	if entries == nil {
+		entries = []HAREntry{}
+	}
+	return HAR{
+		Log: HARLog{
+			Version: harVersion,
+			Creator: HARCreator{
+				Name: harCreatorName,
+			},
+			Entries: entries,
+		},
+	}
+}
+
+// harHeaders converts HTTP headers to HAR name/value pairs.
+func harHeaders(headers http.Header) []HARNameValue {
+	nameValues := []HARNameValue{}
+	for name, values := range headers {
+		for _, value := range values {
+			nameValues = append(nameValues, HARNameValue{Name: name, Value: value})
+		}
+	}
+	return nameValues
+}
+
+// harQueryString converts a raw query string to HAR name/value pairs.
+func harQueryString(rawQuery string) []HARNameValue {
+	nameValues := []HARNameValue{}
+	query, _ := url.ParseQuery(rawQuery) // best effort
+	for name, values := range query {
+		for _, value := range values {
+			nameValues = append(nameValues, HARNameValue{Name: name, Value: value})
+		}
+	}
+	return nameValues
+}
+
+// harCookies converts cookies to HAR cookies.
+func harCookies(cookies []*http.Cookie) []HARCookie {
+	harCookies := []HARCookie{}
+	for _, cookie := range cookies {
+		harCookies = append(harCookies, HARCookie{Name: cookie.Name, Value: cookie.Value})
+	}
+	return harCookies
+}
+
+// harDuration converts a duration to HAR milliseconds.
+func harDuration(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
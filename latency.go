@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// params
+	latencyQueryParamDistribution string = "distribution"
+	latencyQueryParamMin          string = "min"
+	latencyQueryParamMax          string = "max"
+	latencyQueryParamMean         string = "mean"
+	latencyQueryParamStdDev       string = "stddev"
+
+	// distributions
+	latencyDistributionConstant    string = "constant"
+	latencyDistributionUniform     string = "uniform"
+	latencyDistributionNormal      string = "normal"
+	latencyDistributionExponential string = "exponential"
+	latencyDistributionLogNormal   string = "lognormal"
+	latencyDistributionPercentiles string = "percentiles"
+)
+
+var (
+	latencyDistributions = []string{latencyDistributionConstant, latencyDistributionUniform, latencyDistributionNormal, latencyDistributionExponential, latencyDistributionLogNormal, latencyDistributionPercentiles}
+
+	// percentiles query params, and their quantile
+	latencyPercentiles = []struct {
+		param    string
+		quantile float64
+	}{{"p50", 0.5}, {"p90", 0.9}, {"p99", 0.99}, {"p999", 0.999}}
+)
+
+// LatencyDistribution samples random latencies.
+type LatencyDistribution struct {
+	Distribution string
+	Duration     time.Duration // constant
+	Min, Max     time.Duration // bounds, Max is 0 if unbounded
+	Mean, StdDev time.Duration
+	quantiles    []latencyQuantile // percentiles profile, from 0 to 1
+}
+
+type latencyQuantile struct {
+	quantile float64
+	duration time.Duration
+}
+
+// ParseLatencyDistribution parses the distribution query parameter, and the
+// parameters of the distribution. The constant distribution uses the given
+// duration unless the duration query parameter is set.
+func ParseLatencyDistribution(query url.Values, duration time.Duration) (d LatencyDistribution, err error) {
+	d.Distribution = query.Get(latencyQueryParamDistribution)
+	if len(d.Distribution) == 0 {
+		d.Distribution = latencyDistributionConstant
+	} else if !slices.Contains(latencyDistributions, d.Distribution) {
+		return d, errors.Errorf("unknown distribution: %q, must be one of: %s", d.Distribution, strings.Join(latencyDistributions, ", "))
+	}
+
+	// parameters
+	type durationParam struct {
+		name  string
+		value *time.Duration
+	}
+	percentiles := make([]time.Duration, len(latencyPercentiles))
+	params := []durationParam{
+		{queryParamDuration, &duration},
+		{latencyQueryParamMin, &d.Min},
+		{latencyQueryParamMax, &d.Max},
+		{latencyQueryParamMean, &d.Mean},
+		{latencyQueryParamStdDev, &d.StdDev},
+	}
+	for i, percentile := range latencyPercentiles {
+		params = append(params, durationParam{percentile.param, &percentiles[i]})
+	}
+	for _, param := range params {
+		if valueString := query.Get(param.name); len(valueString) > 0 {
+			if *param.value, err = time.ParseDuration(valueString); err != nil {
+				return d, errors.WithMessagef(err, "%s is incorrect", param.name)
+			} else if *param.value < 0 {
+				return d, errors.Errorf("%s is inferior to zero: %s", param.name, param.value.String())
+			}
+		}
+	}
+	if d.Max > 0 && d.Max < d.Min {
+		return d, errors.Errorf("max (%s) is inferior to min (%s)", d.Max, d.Min)
+	}
+
+	// distribution parameters
+	switch d.Distribution {
+	case latencyDistributionConstant:
+		d.Duration = duration
+	case latencyDistributionUniform:
+		if !query.Has(latencyQueryParamMax) {
+			return d, errors.New("max must be set with the uniform distribution")
+		}
+	case latencyDistributionNormal, latencyDistributionLogNormal:
+		if d.Mean == 0 {
+			return d, errors.Errorf("mean must be set with the %s distribution", d.Distribution)
+		}
+	case latencyDistributionExponential:
+		if d.Mean == 0 {
+			return d, errors.New("mean must be set with the exponential distribution")
+		}
+	case latencyDistributionPercentiles:
+		// quantile function, linear between the given percentiles
+		d.quantiles = []latencyQuantile{{0, d.Min}}
+		for i, percentile := range latencyPercentiles {
+			if !query.Has(percentile.param) {
+				continue
+			}
+			duration := percentiles[i]
+			if previous := d.quantiles[len(d.quantiles)-1]; duration < previous.duration {
+				return d, errors.Errorf("%s (%s) is inferior to the previous percentile or min (%s)", percentile.param, duration, previous.duration)
+			}
+			d.quantiles = append(d.quantiles, latencyQuantile{percentile.quantile, duration})
+		}
+		if len(d.quantiles) == 1 {
+			return d, errors.New("at least one percentile (p50, p90, p99 or p999) must be set with the percentiles distribution")
+		}
+		last := d.quantiles[len(d.quantiles)-1].duration
+		if d.Max > 0 && d.Max < last {
+			return d, errors.Errorf("max (%s) is inferior to the last percentile (%s)", d.Max, last)
+		}
+		d.quantiles = append(d.quantiles, latencyQuantile{1, max(d.Max, last)})
+	}
+	return d, nil
+}
+
+// Sample returns a random latency, within the bounds (the constant
+// distribution is not bounded).
+func (d LatencyDistribution) Sample() time.Duration {
+	var sample float64
+	switch d.Distribution {
+	case latencyDistributionConstant:
+		return d.Duration
+	case latencyDistributionUniform:
+		sample = float64(d.Min) + rand.Float64()*float64(d.Max-d.Min)
+	case latencyDistributionNormal:
+		sample = float64(d.Mean) + rand.NormFloat64()*float64(d.StdDev)
+	case latencyDistributionExponential:
+		sample = rand.ExpFloat64() * float64(d.Mean)
+	case latencyDistributionLogNormal:
+		// parameters of the underlying normal distribution, from the mean and
+		// standard deviation of the latency
+		mean, stdDev := float64(d.Mean), float64(d.StdDev)
+		sigma := math.Sqrt(math.Log(1 + stdDev*stdDev/(mean*mean)))
+		mu := math.Log(mean) - sigma*sigma/2
+		sample = math.Exp(mu + rand.NormFloat64()*sigma)
+	case latencyDistributionPercentiles:
+		u := rand.Float64()
+		for i := 1; i < len(d.quantiles); i++ {
+			if low, high := d.quantiles[i-1], d.quantiles[i]; u <= high.quantile {
+				sample = float64(low.duration) + (u-low.quantile)/(high.quantile-low.quantile)*float64(high.duration-low.duration)
+				break
+			}
+		}
+	}
+	sample = max(sample, float64(d.Min))
+	if d.Max > 0 {
+		sample = min(sample, float64(d.Max))
+	}
+	if sample >= float64(math.MaxInt64) {
+		return time.Duration(math.MaxInt64) // the conversion would overflow
+	}
+	return time.Duration(sample)
+}
+
+func (d LatencyDistribution) String() string {
+	switch d.Distribution {
+	case latencyDistributionConstant:
+		return d.Duration.String()
+	case latencyDistributionUniform:
+		return fmt.Sprintf("uniform(%s, %s)", d.Min, d.Max)
+	case latencyDistributionNormal, latencyDistributionLogNormal:
+		return fmt.Sprintf("%s(mean: %s, stddev: %s)", d.Distribution, d.Mean, d.StdDev)
+	case latencyDistributionExponential:
+		return fmt.Sprintf("exponential(mean: %s)", d.Mean)
+	default:
+		quantiles := []string{}
+		for _, quantile := range d.quantiles {
+			quantiles = append(quantiles, fmt.Sprintf("p%g: %s", quantile.quantile*100, quantile.duration))
+		}
+		return "percentiles(" + strings.Join(quantiles, ", ") + ")"
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseLatencyDistribution(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		duration time.Duration
+		want     string // String() of the distribution
+		wantErr  bool
+	}{
+		{name: "default constant", query: "", duration: time.Second, want: "1s"},
+		{name: "constant with duration", query: "distribution=constant&duration=200ms", duration: time.Second, want: "200ms"},
+		{name: "unknown distribution", query: "distribution=gamma", wantErr: true},
+		{name: "incorrect duration", query: "duration=abc", wantErr: true},
+		{name: "negative min", query: "distribution=uniform&min=-1s&max=2s", wantErr: true},
+		{name: "max inferior to min", query: "distribution=uniform&min=2s&max=1s", wantErr: true},
+		{name: "uniform", query: "distribution=uniform&min=100ms&max=200ms", want: "uniform(100ms, 200ms)"},
+		{name: "uniform without max", query: "distribution=uniform&min=100ms", wantErr: true},
+		{name: "normal", query: "distribution=normal&mean=100ms&stddev=20ms", want: "normal(mean: 100ms, stddev: 20ms)"},
+		{name: "normal without mean", query: "distribution=normal&stddev=20ms", wantErr: true},
+		{name: "lognormal", query: "distribution=lognormal&mean=1s&stddev=500ms", want: "lognormal(mean: 1s, stddev: 500ms)"},
+		{name: "exponential", query: "distribution=exponential&mean=50ms", want: "exponential(mean: 50ms)"},
+		{name: "exponential without mean", query: "distribution=exponential", wantErr: true},
+		{name: "percentiles", query: "distribution=percentiles&p50=10ms&p99=100ms", want: "percentiles(p0: 0s, p50: 10ms, p99: 100ms, p100: 100ms)"},
+		{name: "percentiles with bounds", query: "distribution=percentiles&min=5ms&max=1s&p50=10ms&p999=500ms", want: "percentiles(p0: 5ms, p50: 10ms, p99.9: 500ms, p100: 1s)"},
+		{name: "percentiles without percentile", query: "distribution=percentiles&min=5ms", wantErr: true},
+		{name: "decreasing percentiles", query: "distribution=percentiles&p50=100ms&p90=10ms", wantErr: true},
+		{name: "percentile inferior to min", query: "distribution=percentiles&min=50ms&p50=10ms", wantErr: true},
+		{name: "max inferior to the last percentile", query: "distribution=percentiles&max=50ms&p99=100ms", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := url.ParseQuery(test.query)
+			if err != nil {
+				t.Fatalf("invalid test query: %v", err)
+			}
+			d, err := ParseLatencyDistribution(query, test.duration)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got distribution: %s", d)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if d.String() != test.want {
+				t.Errorf("got distribution %s, want %s", d, test.want)
+			}
+		})
+	}
+}
+
+func TestLatencyDistributionSampleBounds(t *testing.T) {
+	queries := []string{
+		"distribution=uniform&min=100ms&max=200ms",
+		"distribution=normal&mean=150ms&stddev=100ms&min=100ms&max=200ms",
+		"distribution=lognormal&mean=150ms&stddev=100ms&min=100ms&max=200ms",
+		"distribution=exponential&mean=150ms&min=100ms&max=200ms",
+		"distribution=percentiles&min=100ms&p50=120ms&p99=180ms&max=200ms",
+	}
+	for _, queryString := range queries {
+		t.Run(queryString, func(t *testing.T) {
+			query, _ := url.ParseQuery(queryString)
+			d, err := ParseLatencyDistribution(query, 0)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for range 1000 {
+				if sample := d.Sample(); sample < d.Min || sample > d.Max {
+					t.Fatalf("sample %s is outside of [%s, %s]", sample, d.Min, d.Max)
+				}
+			}
+		})
+	}
+}
+
+func TestLatencyDistributionSampleOverflow(t *testing.T) {
+	query, _ := url.ParseQuery("distribution=normal&mean=2562047h&stddev=1000000h")
+	d, err := ParseLatencyDistribution(query, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for range 1000 {
+		if sample := d.Sample(); sample < 0 {
+			t.Fatalf("sample %s overflowed", sample)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maximum number of raw bytes kept per request, must be big enough to hold
+	// the request headers
+	recordingConnBufferSize int = 64 * 1024 // 64KiB
+
+	// reasons why the raw headers of a request are not available
+	rawHeadersUnavailableTLS         string = "tls"
+	rawHeadersUnavailableHTTP2       string = "http2"
+	rawHeadersUnavailableNotRecorded string = "not_recorded"
+	rawHeadersUnavailableRequestLine string = "request_line_not_recorded"
+	rawHeadersUnavailableTooLarge    string = "headers_too_large"
+	rawHeadersUnavailableMalformed   string = "malformed_headers"
+)
+
+var (
+	recordingConnHeadersEnd = []byte("\r\n\r\n")
+)
+
+type recordingConnContextKey struct{}
+
+// RecordingListener wraps accepted connections so the raw headers of the
+// requests can be retrieved, since net/http doesn't keep their order nor their
+// case. It is only used for plain text connections.
+type RecordingListener struct {
+	net.Listener
+}
+
+func NewRecordingListener(listener net.Listener) RecordingListener {
+	return RecordingListener{Listener: listener}
+}
+
+func (l RecordingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	recording := &recordingConn{
+		Conn: conn,
+		lock: &sync.Mutex{},
+	}
+	recording.recording.Store(true)
+	return recording, nil
+}
+
+// recordingConn keeps the bytes read from the connection until the end of
+// the request headers, the rest of the request is not recorded. The
+// recording starts over when the connection becomes idle (see
+// RecordingConnState).
+type recordingConn struct {
+	net.Conn
+	recording atomic.Bool
+	lock      *sync.Mutex
+	buffer    []byte
+}
+
+func (c *recordingConn) Read(p []byte) (n int, err error) {
+	n, err = c.Conn.Read(p)
+	if n == 0 || !c.recording.Load() {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	from := max(0, len(c.buffer)-len(recordingConnHeadersEnd)+1) // the end of the headers may be split across reads
+	c.buffer = append(c.buffer, p[:min(n, recordingConnBufferSize-len(c.buffer))]...)
+	if bytes.Contains(c.buffer[from:], recordingConnHeadersEnd) || len(c.buffer) >= recordingConnBufferSize {
+		c.recording.Store(false)
+	}
+	return
+}
+
+// NetConn returns the underlying connection.
+func (c *recordingConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// RecordingConnContext stores recording connections in the request context,
+// to be used as the http.Server ConnContext.
+func RecordingConnContext(ctx context.Context, c net.Conn) context.Context {
+	if conn, ok := c.(*recordingConn); ok {
+		return context.WithValue(ctx, recordingConnContextKey{}, conn)
+	}
+	return ctx
+}
+
+// RecordingConnState starts the recording over when a connection becomes
+// idle, to record the headers of its next request. To be used as the
+// http.Server ConnState.
+func RecordingConnState(c net.Conn, state http.ConnState) {
+	if conn, ok := c.(*recordingConn); ok && state == http.StateIdle {
+		conn.lock.Lock()
+		conn.buffer = conn.buffer[:0]
+		conn.lock.Unlock()
+		conn.recording.Store(true)
+	}
+}
+
+// RawHeader is a request header as received.
+type RawHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// rawRequestHeaders returns the headers of the request in the order (and
+// case) they were received. If they are not available (HTTP/2 or TLS
+// connections, headers bigger than the buffer, or pipelined requests), the
+// reason is one of the rawHeadersUnavailable constants and an error explains
+// it.
+func rawRequestHeaders(r *http.Request) (headers []RawHeader, reason string, err error) {
+	conn, ok := r.Context().Value(recordingConnContextKey{}).(*recordingConn)
+	if r.ProtoMajor != 1 {
+		return nil, rawHeadersUnavailableHTTP2, errors.Errorf("the received order is not recorded with %s", r.Proto)
+	} else if r.TLS != nil {
+		return nil, rawHeadersUnavailableTLS, errors.New("the received order is not recorded on TLS connections")
+	} else if !ok {
+		return nil, rawHeadersUnavailableNotRecorded, errors.New("the received order is not recorded on this connection")
+	}
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	// the buffer holds the headers of the request being processed
+	requestLine := []byte(r.Method + " " + r.RequestURI + " " + r.Proto + "\r\n")
+	start := bytes.LastIndex(conn.buffer, requestLine)
+	if start < 0 {
+		if len(conn.buffer) >= recordingConnBufferSize {
+			return nil, rawHeadersUnavailableTooLarge, errors.Errorf("the headers are bigger than %s", SizeToHumanReadable(float64(recordingConnBufferSize)))
+		}
+		return nil, rawHeadersUnavailableRequestLine, errors.New("the request line was not recorded")
+	}
+	block := conn.buffer[start+len(requestLine):]
+	end := bytes.Index(block, recordingConnHeadersEnd)
+	if end < 0 {
+		return nil, rawHeadersUnavailableTooLarge, errors.Errorf("the headers are bigger than %s", SizeToHumanReadable(float64(recordingConnBufferSize)))
+	}
+
+	for _, line := range strings.Split(string(block[:end]), "\r\n") {
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			return nil, rawHeadersUnavailableMalformed, errors.Errorf("malformed header line: %q", line)
+		}
+		headers = append(headers, RawHeader{Name: name, Value: strings.TrimSpace(value)})
+	}
+	return headers, "", nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRecordingConnKeepAlive(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			headers, reason, err := rawRequestHeaders(r)
+			if err != nil {
+				w.Write([]byte("unavailable: " + reason))
+				return
+			}
+			names := []string{}
+			for _, header := range headers {
+				names = append(names, header.Name)
+			}
+			w.Write([]byte(strings.Join(names, ",")))
+		}),
+		ConnContext: RecordingConnContext,
+		ConnState:   RecordingConnState,
+	}
+	go server.Serve(NewRecordingListener(listener))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// requests sent one after the other on the same connection, the recording
+	// starts over once the connection is idle
+	tests := []struct {
+		name    string
+		request string
+		want    string
+	}{
+		{name: "first request", request: "GET /first HTTP/1.1\r\nHost: test\r\nx-first: 1\r\nAccept: */*\r\n\r\n", want: "Host,x-first,Accept"},
+		{name: "second request", request: "GET /second HTTP/1.1\r\nX-Second: 2\r\nHost: test\r\n\r\n", want: "X-Second,Host"},
+		{name: "headers too large", request: "GET /large HTTP/1.1\r\nHost: test\r\nX-Large: " + strings.Repeat("a", recordingConnBufferSize) + "\r\n\r\n", want: "unavailable: " + rawHeadersUnavailableTooLarge},
+		{name: "request after too large headers", request: "GET /third HTTP/1.1\r\nHost: test\r\nX-Third: 3\r\n\r\n", want: "Host,X-Third"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := conn.Write([]byte(test.request)); err != nil {
+				t.Fatalf("failed to send request: %v", err)
+			}
+			answer, err := http.ReadResponse(reader, nil)
+			if err != nil {
+				t.Fatalf("failed to read answer: %v", err)
+			}
+			defer answer.Body.Close()
+			body := make([]byte, answer.ContentLength)
+			if _, err = io.ReadFull(answer.Body, body); err != nil {
+				t.Fatalf("failed to read answer body: %v", err)
+			}
+			if string(body) != test.want {
+				t.Errorf("got %q, want %q", body, test.want)
+			}
+		})
+	}
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
@@ -42,54 +47,152 @@ func main() {
 	// basic auth
 	basicAuthMiddleware := NewBasicAuthMiddleWare(config.BasicAuthUsername, config.BasicAuthPassword)
 
+	// capture
+	captureEndpoints := NewCaptureEndpoints(config.CaptureSize, config.CaptureBodySize)
+	http.HandleFunc("/capture", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(captureEndpoints.List)))))
+	http.HandleFunc("/capture/replay", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(captureEndpoints.Replay)))))
+	http.HandleFunc("/capture/reset", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(captureEndpoints.Reset)))))
+
+	// webhooks
+	hooksEndpoints := NewHooksEndpoints(config.HooksSize, config.HooksBodySize)
+	http.HandleFunc("/hooks/{bucket}", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(hooksEndpoints.Deliver))))))
+	http.HandleFunc("/hooks/{bucket}/deliveries", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(hooksEndpoints.Deliveries)))))
+	http.HandleFunc("/hooks/{bucket}/wait", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(hooksEndpoints.Wait)))))
+	http.HandleFunc("/hooks/{bucket}/reset", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(hooksEndpoints.Reset)))))
+	http.HandleFunc("/hooks/{bucket}/verify", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(hooksEndpoints.Verify)))))
+
+	// sequences
+	sequenceEndpoints := NewSequenceEndpoints()
+	http.HandleFunc("/sequence", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.Configure))))))
+	http.HandleFunc("/sequence/delete", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.Delete))))))
+	http.HandleFunc("/sequence/reset", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.Reset))))))
+	http.HandleFunc("/sequence/status", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.Status))))))
+	http.HandleFunc("/mock/", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/mock/", sequenceEndpoints.Mock))))))) // trailing '/' in the path is needed
+
+	// storage
+	storageEndpoints := NewStorageEndpoints(config.StorageFolder)
+	if len(config.StorageFolder) > 0 {
+		http.HandleFunc("/storage", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(storageEndpoints.List))))))
+		http.HandleFunc("/storage/{key...}", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(storageEndpoints.File))))))
+	}
+
 	// routing endpoints
-	http.HandleFunc("/crash", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(crash)))))
-	http.HandleFunc("/download", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(download)))))
-	http.HandleFunc("/echo", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(echo)))))
-	http.HandleFunc("/echo/form", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(echoForm)))))
-	http.HandleFunc("/echo/raw", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(LogMiddleware(echoRaw))))
-	http.HandleFunc("/ping", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(ping)))))
-	http.HandleFunc("/request", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(request)))))
-	http.HandleFunc("/sleep", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(sleep)))))
-	http.HandleFunc("/status_code", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(statusCode)))))
-	http.HandleFunc("/tcp", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(tcp)))))
-	http.HandleFunc("/upload", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(upload)))))
+	crashEndpoints := NewCrashEndpoints()
+	http.HandleFunc("/crash", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/crash", crashEndpoints.Crash)))))))
+	http.HandleFunc("/download", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/download", download)))))))
+	http.HandleFunc("/drip", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/drip", drip)))))))
+	http.HandleFunc("/echo", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/echo", echo)))))))
+	http.HandleFunc("/echo/form", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/echo/form", echoForm)))))))
+	http.HandleFunc("/echo/stream", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/echo/stream", echoStream))))))
+	tlsEndpoints := NewTLSEndpoints()
+	http.HandleFunc("/echo/tls", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/echo/tls", tlsEndpoints.Echo)))))))
+	http.HandleFunc("/echo/raw", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/echo/raw", echoRaw))))))
+	http.HandleFunc("/fault/rst", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/fault/rst", faultRST)))))))
+	http.HandleFunc("/fault/close_after_headers", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/fault/close_after_headers", faultCloseAfterHeaders)))))))
+	http.HandleFunc("/fault/truncated_body", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/fault/truncated_body", faultTruncatedBody)))))))
+	http.HandleFunc("/fault/malformed_status", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/fault/malformed_status", faultMalformedStatus)))))))
+	http.HandleFunc("/fault/invalid_chunked", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/fault/invalid_chunked", faultInvalidChunked)))))))
+	http.HandleFunc("/fault/slow_headers", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/fault/slow_headers", faultSlowHeaders)))))))
+	http.HandleFunc("/fault/half_close", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/fault/half_close", faultHalfClose)))))))
+	http.HandleFunc("/ping", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/ping", ping)))))))
+	http.HandleFunc("/redirect", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/redirect", redirect)))))))
+	http.HandleFunc("/request", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/request", request)))))))
+	http.HandleFunc("/sleep", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/sleep", sleep)))))))
+	http.HandleFunc("/status_code", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/status_code", statusCode)))))))
+	http.HandleFunc("/tcp", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/tcp", tcp)))))))
+	http.HandleFunc("/upload", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/upload", storageEndpoints.Upload)))))))
+	http.HandleFunc("/whoami", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/whoami", whoami)))))))
+	// cookies
+	cookieEndpoints := NewCookieEndpoints()
+	http.HandleFunc("/cookie/set", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/cookie/set", cookieEndpoints.Set)))))))
+	http.HandleFunc("/cookie/list", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/cookie/list", cookieEndpoints.List)))))))
+	http.HandleFunc("/cookie/delete", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/cookie/delete", cookieEndpoints.Delete)))))))
+	http.HandleFunc("/session", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/session", cookieEndpoints.Session)))))))
 	// databases
 	databaseEndpoints := NewDatabaseEndpoints()
-	http.HandleFunc("/database/connect", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(databaseEndpoints.Connect)))))
-	http.HandleFunc("/database/query", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(databaseEndpoints.Query)))))
+	http.HandleFunc("/database/connect", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/database/connect", databaseEndpoints.Connect)))))))
+	http.HandleFunc("/database/query", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(sequenceEndpoints.MiddleWare("/database/query", databaseEndpoints.Query)))))))
 	// CPU
 	cpuEndpoints := NewCPUEndpoints()
-	http.HandleFunc("/cpu/load", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(cpuEndpoints.Load)))))
-	http.HandleFunc("/cpu/reset", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(cpuEndpoints.Reset)))))
+	http.HandleFunc("/cpu/load", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(cpuEndpoints.Load))))))
+	http.HandleFunc("/cpu/reset", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(cpuEndpoints.Reset))))))
 	// RAM
 	ramEndpoints := NewRAMEndpoints()
-	http.HandleFunc("/ram/increase", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(ramEndpoints.Increase)))))
-	http.HandleFunc("/ram/decrease", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(ramEndpoints.Decrease)))))
-	http.HandleFunc("/ram/leak", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(ramEndpoints.Leak)))))
-	http.HandleFunc("/ram/reset", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(ramEndpoints.Reset)))))
-	http.HandleFunc("/ram/status", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(ramEndpoints.Status)))))
+	http.HandleFunc("/ram/increase", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(ramEndpoints.Increase))))))
+	http.HandleFunc("/ram/decrease", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(ramEndpoints.Decrease))))))
+	http.HandleFunc("/ram/leak", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(ramEndpoints.Leak))))))
+	http.HandleFunc("/ram/reset", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(ramEndpoints.Reset))))))
+	http.HandleFunc("/ram/status", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(ramEndpoints.Status))))))
+	diskEndpoints := NewDiskEndpoints(config.DiskFolder)
+	http.HandleFunc("/disk/fill", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(diskEndpoints.Fill))))))
+	http.HandleFunc("/disk/leak", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(diskEndpoints.Leak))))))
+	http.HandleFunc("/disk/io", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(diskEndpoints.IO))))))
+	http.HandleFunc("/disk/benchmark", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(diskEndpoints.Benchmark))))))
+	http.HandleFunc("/disk/reset", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(diskEndpoints.Reset))))))
+	http.HandleFunc("/disk/status", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(diskEndpoints.Status))))))
+	// leaks
+	leakEndpoints := NewLeakEndpoints()
+	http.HandleFunc("/leak/file", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(leakEndpoints.File))))))
+	http.HandleFunc("/leak/socket", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(leakEndpoints.Socket))))))
+	http.HandleFunc("/leak/goroutine", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(leakEndpoints.Goroutine))))))
+	http.HandleFunc("/leak/reset", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(leakEndpoints.Reset))))))
+	http.HandleFunc("/leak/status", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(HeadersMiddleWare(LogMiddleware(leakEndpoints.Status))))))
 	// ui
 	http.HandleFunc("/ui/", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(http.StripPrefix("/ui/", http.FileServer(http.Dir("./ui"))).ServeHTTP))) // trailing '/' in the path is needed
 	// static folder
 	if len(config.StaticFolder) > 0 {
-		http.HandleFunc("/static/", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(http.StripPrefix("/static/", http.FileServer(http.Dir(config.StaticFolder))).ServeHTTP))) // trailing '/' in the path is needed
+		http.HandleFunc("/static/", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(captureEndpoints.MiddleWare(http.StripPrefix("/static/", http.FileServer(http.Dir(config.StaticFolder))).ServeHTTP)))) // trailing '/' in the path is needed
 	}
 	// monitoring
 	monitoringEndpoints := NewMonitoringEndpoints(config.MonitoringConfig)
 	http.HandleFunc("/started", LogRequestMiddleWare(HeadersMiddleWare(LogMiddleware(monitoringEndpoints.Startup))))
 	http.HandleFunc("/alive", LogRequestMiddleWare(HeadersMiddleWare(LogMiddleware(monitoringEndpoints.Liveness))))
 	http.HandleFunc("/ready", LogRequestMiddleWare(HeadersMiddleWare(LogMiddleware(monitoringEndpoints.Readiness))))
+	// state
+	stateEndpoints := NewStateEndpoints(config.StateFile, cpuEndpoints, ramEndpoints, diskEndpoints, leakEndpoints, monitoringEndpoints, crashEndpoints, sequenceEndpoints, databaseEndpoints, hooksEndpoints, captureEndpoints)
+	http.HandleFunc("/state", LogRequestMiddleWare(basicAuthMiddleware.MiddleWare(HeadersMiddleWare(LogMiddleware(stateEndpoints.Status)))))
+	if err = stateEndpoints.Restore(config.StateRestoreRAM); err != nil {
+		log.WithError(err).Fatal("failed to restore state")
+	}
 
 	// HTTP server
+	server := &http.Server{
+		Addr:        config.ListenOn,
+		ConnContext: RecordingConnContext,
+	}
+	// shutting down gracefully (and saving the pending state changes) when the server is stopped
+	shutdownDone := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		defer close(shutdownDone)
+		log.Infof("received signal %s, shutting down within %s", <-signals, config.ShutdownTimeout.String())
+		signal.Stop(signals)   // a second signal stops the server right away
+		stateEndpoints.Flush() // no-op without state file
+		ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.WithError(err).Warn("failed to shut down the HTTP server gracefully, closing the remaining connections")
+			server.Close()
+		}
+		stateEndpoints.Flush() // changes made by the requests answered meanwhile
+	}()
 	log.Infof("server is now listening on: %s", config.ListenOn)
 	if len(config.TLSCert) > 0 {
-		err = http.ListenAndServeTLS(config.ListenOn, config.TLSCert, config.TLSKey, nil)
+		server.TLSConfig = &tls.Config{GetConfigForClient: tlsEndpoints.GetConfigForClient}
+		server.ConnState = tlsEndpoints.ConnState
+		err = server.ListenAndServeTLS(config.TLSCert, config.TLSKey)
 	} else {
-		err = http.ListenAndServe(config.ListenOn, nil)
+		var listener net.Listener
+		if listener, err = net.Listen("tcp", config.ListenOn); err != nil {
+			log.WithError(err).Fatal("failed to listen")
+		}
+		server.ConnState = RecordingConnState
+		err = server.Serve(NewRecordingListener(listener)) // raw headers are only available in plain text
 	}
 	if errors.Is(err, http.ErrServerClosed) {
 		log.Debug("HTTP server closed")
+		<-shutdownDone // waiting for the in-flight requests
 	} else if err != nil {
 		log.WithError(err).Fatal("failed to start the HTTP server")
 	}
@@ -114,6 +114,12 @@ func (w *responseWriterInspector) WriteHeader(statusCode int) {
 	w.status = statusCode
 }
 
+// Unwrap returns the original response writer, used by http.ResponseController
+// to flush or hijack the connection.
+func (w *responseWriterInspector) Unwrap() http.ResponseWriter {
+	return w.w
+}
+
 func (w *responseWriterInspector) GetStatus() int {
 	return w.status
 }
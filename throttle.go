@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// throttled transfers are split in chunks sent every 1/10th of a second
+	throttleChunksPerSecond int64 = 10
+	throttleMaxChunkSize    int64 = 32 * 1024 // 32KiB
+)
+
+// Throttle limits the bandwidth of a transfer.
+type Throttle struct {
+	Rate   int64         // in bytes per second, 0 if unlimited
+	Jitter int           // percentage of the rate randomly applied to each chunk
+	Delay  time.Duration // before the first byte
+}
+
+// ParseThrottle parses the rate, jitter and delay query parameters.
+func ParseThrottle(query url.Values) (t Throttle, err error) {
+	// rate
+	if rateString := query.Get(queryParamRate); len(rateString) > 0 {
+		if !positiveIntegerRegex.MatchString(rateString) {
+			return t, errors.New("rate doesn't match regex: " + positiveIntegerRegex.String())
+		}
+		if t.Rate, err = strconv.ParseInt(rateString, 10, 64); err != nil {
+			return t, errors.WithMessage(err, "rate is incorrect")
+		}
+	}
+	// jitter
+	if jitterString := query.Get(queryParamJitter); len(jitterString) > 0 {
+		if !positiveIntegerRegex.MatchString(jitterString) {
+			return t, errors.New("jitter doesn't match regex: " + positiveIntegerRegex.String())
+		}
+		t.Jitter, _ = strconv.Atoi(jitterString) // can't fail thanks to the regexp
+		if t.Jitter > 100 {
+			return t, errors.Errorf("jitter is superior to 100%% (value: %d)", t.Jitter)
+		} else if t.Rate == 0 && t.Jitter > 0 {
+			return t, errors.New("jitter can only be set with a rate")
+		}
+	}
+	// delay
+	if delayString := query.Get(queryParamDelay); len(delayString) > 0 {
+		if t.Delay, err = time.ParseDuration(delayString); err != nil {
+			return t, errors.WithMessage(err, "delay is incorrect")
+		} else if t.Delay < 0 {
+			return t, errors.New("delay is inferior to zero: " + t.Delay.String())
+		}
+	}
+	return
+}
+
+// parseThrottle parses the throttle query parameters. If they are incorrect,
+// the error is written to the client and ok is false.
+func parseThrottle(l *log.Entry, w http.ResponseWriter, query url.Values) (t Throttle, ok bool) {
+	t, err := ParseThrottle(query)
+	if err != nil {
+		errorString := err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorString))
+		l.Warn(errorString)
+		return t, false
+	}
+	return t, true
+}
+
+// Wait waits for the initial delay, or until the context is done.
+func (t Throttle) Wait(ctx context.Context) error {
+	return sleepContext(ctx, t.Delay)
+}
+
+// Reader throttles the reads of r (unless the rate is unlimited).
+func (t Throttle) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if t.Rate == 0 {
+		return r
+	}
+	return &throttledReader{Reader: r, pacer: newPacer(ctx, t)}
+}
+
+// ResponseWriter throttles the writes to w, flushing each chunk (unless the
+// rate is unlimited).
+func (t Throttle) ResponseWriter(ctx context.Context, w http.ResponseWriter) http.ResponseWriter {
+	if t.Rate == 0 {
+		return w
+	}
+	return &throttledResponseWriter{ResponseWriter: w, controller: http.NewResponseController(w), pacer: newPacer(ctx, t)}
+}
+
+func (t Throttle) String() string {
+	if t.Rate == 0 {
+		return "unlimited"
+	}
+	return SizeToHumanReadable(float64(t.Rate)) + "/s (jitter: " + strconv.Itoa(t.Jitter) + "%)"
+}
+
+// pacer schedules the chunks of a throttled transfer.
+type pacer struct {
+	ctx       context.Context
+	throttle  Throttle
+	chunkSize int
+	next      time.Time // when the next chunk can be transferred
+}
+
+func newPacer(ctx context.Context, t Throttle) *pacer {
+	return &pacer{
+		ctx:       ctx,
+		throttle:  t,
+		chunkSize: int(min(max(t.Rate/throttleChunksPerSecond, 1), throttleMaxChunkSize)),
+	}
+}
+
+// pace waits until the next chunk can be transferred, n bytes have just been
+// transferred.
+func (p *pacer) pace(n int) error {
+	// not catching up more than a second of delay (slow peer), to avoid bursts
+	if now := time.Now(); p.next.Before(now.Add(-time.Second)) {
+		p.next = now
+	}
+	duration := float64(n) / float64(p.throttle.Rate) * float64(time.Second)
+	if p.throttle.Jitter > 0 {
+		duration *= 1 + (rand.Float64()*2-1)*float64(p.throttle.Jitter)/100
+	}
+	p.next = p.next.Add(time.Duration(duration))
+	return sleepContext(p.ctx, time.Until(p.next))
+}
+
+type throttledReader struct {
+	io.Reader
+	pacer *pacer
+}
+
+func (r *throttledReader) Read(p []byte) (n int, err error) {
+	n, err = r.Reader.Read(p[:min(len(p), r.pacer.chunkSize)])
+	if n > 0 {
+		if paceErr := r.pacer.pace(n); paceErr != nil && err == nil {
+			err = paceErr
+		}
+	}
+	return
+}
+
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	controller *http.ResponseController
+	pacer      *pacer
+}
+
+func (w *throttledResponseWriter) Write(p []byte) (written int, err error) {
+	for len(p) > 0 {
+		chunk := p[:min(len(p), w.pacer.chunkSize)]
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		w.controller.Flush()
+		if err = w.pacer.pace(n); err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return
+}
+
+func (w *throttledResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// sleepContext sleeps for the duration, or until the context is done.
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	if duration <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
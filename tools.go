@@ -41,6 +41,9 @@ func sizeToHumanReadable(size float64, decimals, power int) string {
 
 var (
 	uuidLetters = []rune("0123456789abcdef")
+
+	// InstanceID identifies the running instance, it changes at each start.
+	InstanceID = GenerateUUID()
 )
 
 // GenerateUUID generates a random UUID and return it in the string format.
@@ -15,6 +15,35 @@ function bodyOnLoad() {
   tcpTLSInsecureChanged(document.getElementById("tcpTLSInsecure").checked);
 }
 
+// state
+function stateGet(button, resultP) {
+  clearOldResult(button, resultP);
+
+  // building request
+  let xhr = new XMLHttpRequest();
+  xhr.onreadystatechange = function() {
+    if (this.readyState == 4) {
+      if (this.status == 200) {
+        let state = JSON.parse(this.responseText);
+        let summary = state.cpu.loads.length + " CPU load(s), "
+          + state.ram.leak_workers.length + " RAM leak worker(s) (" + sizeToString(state.ram.leaked) + " leaked), "
+          + state.crashes.length + " pending crash(es), "
+          + state.sequences.length + " sequence(s), "
+          + state.databases.length + " opened database pool(s)";
+        resultOk(resultP, summary + "<pre>" + escapeHTML(JSON.stringify(state, null, 2)) + "</pre>", button);
+      } else if (this.status != 0) {
+        resultError(resultP, this.responseText, button);
+      } else {
+        resultConnectionError(resultP, button);
+      }
+    }
+  };
+
+  // sending request
+  xhr.open("GET", "/state", true);
+  xhr.send();
+}
+
 // crash
 function crash(button, resultP) {
   clearOldResult(button, resultP);
@@ -74,10 +103,12 @@ function download(button, resultP) {
       resultError(resultP, "download size is negative", button);
       return;
     }
-    url += "?size=" + parseInt(downloadSize);
+    url += "?size=" + parseInt(downloadSize) + "&";
   } else {
+    url += "?";
     downloadSize = 1024*1024;
   }
+  url += "content=" + document.getElementById("downloadContent").value;
 
   // progress bar
   var progressBar = document.getElementById("downloadProgressBar")
@@ -117,6 +148,144 @@ function download(button, resultP) {
   xhr.send();
 }
 
+// drip
+function drip(button, resultP) {
+  clearOldResult(button, resultP);
+
+  // building URL
+  let url = "/drip";
+  let queryParams = new Array();
+  // size
+  var dripSize = document.getElementById("dripSize").value;
+  if (dripSize.length != 0) {
+    queryParams.push("size=" + encodeURIComponent(dripSize.trim()));
+  }
+  // chunk size
+  var dripChunkSize = document.getElementById("dripChunkSize").value;
+  if (dripChunkSize.length != 0) {
+    queryParams.push("chunk_size=" + encodeURIComponent(dripChunkSize.trim()));
+  }
+  // duration
+  var dripDuration = document.getElementById("dripDuration").value;
+  if (dripDuration.length != 0) {
+    queryParams.push("duration=" + encodeURIComponent(dripDuration.trim()));
+  }
+  // delay
+  var dripDelay = document.getElementById("dripDelay").value;
+  if (dripDelay.length != 0) {
+    queryParams.push("delay=" + encodeURIComponent(dripDelay.trim()));
+  }
+  // status code
+  var dripStatusCodeInt = 200;
+  let dripStatusCode = document.getElementById("dripStatusCode").value;
+  if (dripStatusCode.length != 0) {
+    dripStatusCodeInt = parseInt(dripStatusCode);
+    if ((dripStatusCodeInt < 100) || (dripStatusCodeInt > 599)) {
+      resultError(resultP, "status code out of bounds (100 - 599)", button);
+      return;
+    }
+    queryParams.push("code=" + dripStatusCodeInt);
+  }
+  // query params
+  if (queryParams.length > 0) {
+    url += "?" + queryParams.join("&")
+  }
+
+  // building request
+  var startDate = new Date();
+  let xhr = new XMLHttpRequest();
+  xhr.onreadystatechange = function() {
+    if (this.readyState == 4) {
+      if (this.status == dripStatusCodeInt) {
+        resultOk(resultP, this.responseText.length + " bytes received in " + ((new Date() - startDate) / 1000) + "s", button);
+      } else if (this.status != 0) {
+        resultError(resultP, this.responseText, button);
+      } else {
+        resultConnectionError(resultP, button);
+      }
+    }
+  };
+
+  // sending request
+  xhr.open("GET", url, true);
+  xhr.send();
+}
+
+// fault
+function fault(button, resultP) {
+  clearOldResult(button, resultP);
+
+  // building URL
+  let url = "/fault/" + document.getElementById("faultType").value;
+  let queryParams = new Array();
+  // delay
+  var faultDelay = document.getElementById("faultDelay").value;
+  if (faultDelay.length != 0) {
+    queryParams.push("delay=" + encodeURIComponent(faultDelay.trim()));
+  }
+  // query params
+  if (queryParams.length > 0) {
+    url += "?" + queryParams.join("&")
+  }
+
+  // building request
+  let xhr = new XMLHttpRequest();
+  xhr.onreadystatechange = function() {
+    if (this.readyState == 4) {
+      if (this.status == 0) { // the browser can't tell more about broken connections
+        resultOk(resultP, "the request failed as expected, check the browser console for details", button);
+      } else if (this.status == 200) {
+        resultOk(resultP, "server answered after misbehaving: " + this.responseText, button);
+      } else {
+        resultError(resultP, this.responseText.replace(/(?:\r\n|\r|\n)/g, '<br>'), button);
+      }
+    }
+  };
+
+  // sending request
+  xhr.open("GET", url, true);
+  xhr.send();
+}
+
+// redirect
+function redirect(button, resultP) {
+  clearOldResult(button, resultP);
+
+  // building URL
+  let url = "/redirect?code=" + document.getElementById("redirectCode").value;
+  // hops
+  var redirectHops = document.getElementById("redirectHops").value;
+  if (redirectHops.length != 0) {
+    url += "&hops=" + encodeURIComponent(redirectHops.trim());
+  }
+  // absolute
+  if (document.getElementById("redirectAbsolute").checked) {
+    url += "&absolute=true";
+  }
+  // loop
+  if (document.getElementById("redirectLoop").checked) {
+    url += "&loop=true";
+  }
+
+  // building request
+  let xhr = new XMLHttpRequest();
+  xhr.onreadystatechange = function() {
+    if (this.readyState == 4) {
+      if (this.status == 200) {
+        resultOk(resultP, this.responseText + " (final URL: " + this.responseURL + ")", button);
+      } else if (this.status != 0) {
+        resultError(resultP, this.responseText, button);
+      } else {
+        resultError(resultP, "the browser gave up following the redirects, check the browser console for details", button);
+      }
+    }
+  };
+
+  // sending request
+  xhr.open("GET", url, true);
+  xhr.send();
+}
+
 // sleep
 function sleep(button, resultP) {
   clearOldResult(button, resultP);
@@ -131,6 +300,36 @@ function sleep(button, resultP) {
   } else {
     sleepDuration = "1s"
   }
+  // distribution
+  var sleepDistribution = document.getElementById("sleepDistribution").value;
+  if (sleepDistribution != "constant") {
+    queryParams.push("distribution=" + sleepDistribution);
+  }
+  // min
+  var sleepMin = document.getElementById("sleepMin").value;
+  if (sleepMin.length != 0) {
+    queryParams.push("min=" + encodeURIComponent(sleepMin.trim()));
+  }
+  // max
+  var sleepMax = document.getElementById("sleepMax").value;
+  if (sleepMax.length != 0) {
+    queryParams.push("max=" + encodeURIComponent(sleepMax.trim()));
+  }
+  // mean
+  var sleepMean = document.getElementById("sleepMean").value;
+  if (sleepMean.length != 0) {
+    queryParams.push("mean=" + encodeURIComponent(sleepMean.trim()));
+  }
+  // stddev
+  var sleepStdDev = document.getElementById("sleepStdDev").value;
+  if (sleepStdDev.length != 0) {
+    queryParams.push("stddev=" + encodeURIComponent(sleepStdDev.trim()));
+  }
+  // percentiles
+  var sleepPercentiles = document.getElementById("sleepPercentiles").value;
+  if (sleepPercentiles.length != 0) {
+    queryParams.push(sleepPercentiles.trim());
+  }
   // status code
   var sleepStatusCodeInt = 200;
   let sleepStatusCode = document.getElementById("sleepStatusCode").value;
@@ -153,7 +352,7 @@ function sleep(button, resultP) {
   xhr.onreadystatechange = function() {
     if (this.readyState == 4) {
       if (this.status == sleepStatusCodeInt) {
-        resultOk(resultP, "server slept for " + sleepDuration, button);
+        resultOk(resultP, "server slept for " + this.getResponseHeader("X-Sleep-Duration"), button);
       } else if (this.status != 0) {
         resultError(resultP, this.responseText, button);
       } else {
@@ -174,15 +373,29 @@ function statusCode(button, resultP) {
   // building URL
   let url = "/status_code?";
   // status code
-  var statusCodeInt = 200;
-  let statusCode = document.getElementById("statusCodeCode").value;
-  if (statusCode.length != 0) {
+  let statusCode = document.getElementById("statusCodeCode").value.trim();
+  if (statusCode.length == 0) {
+    resultError(resultP, "status code is mandatory", button);
+    return;
+  }
+  url += "code=" + encodeURIComponent(statusCode);
+  // single status code, checked in the response
+  var statusCodeInt = 0;
+  if (/^[1-5][0-9]{2}$/.test(statusCode)) {
     statusCodeInt = parseInt(statusCode);
-    if ((statusCodeInt < 100) || (statusCodeInt > 599)) {
-      resultError(resultP, "status code out of bounds (100 - 599)", button);
-      return;
-    }
-    url += "code=" + statusCodeInt;
+  }
+  // headers
+  let location = document.getElementById("statusCodeLocation").value;
+  if (location.length != 0) {
+    url += "&location=" + encodeURIComponent(location);
+  }
+  let retryAfter = document.getElementById("statusCodeRetryAfter").value;
+  if (retryAfter.length != 0) {
+    url += "&retry_after=" + encodeURIComponent(retryAfter);
+  }
+  let wwwAuthenticate = document.getElementById("statusCodeWWWAuthenticate").value;
+  if (wwwAuthenticate.length != 0) {
+    url += "&www_authenticate=" + encodeURIComponent(wwwAuthenticate);
   }
 
   // building request
@@ -191,6 +404,8 @@ function statusCode(button, resultP) {
     if (this.readyState == 4) {
       if (this.status == statusCodeInt) {
         resultOk(resultP, "server responded with correct status code " + statusCodeInt, button);
+      } else if ((statusCodeInt == 0) && (this.status != 0) && (this.status != 400)) {
+        resultOk(resultP, "server responded with status code " + this.status, button);
       } else if (this.status != 0) {
         resultError(resultP, this.responseText, button);
       } else {
@@ -261,6 +476,173 @@ function upload(button, resultP) {
   xhr.send(formData);
 }
 
+// cookie set
+function cookieSet(button, resultP) {
+  clearOldResult(button, resultP);
+
+  // building URL
+  let cookieName = document.getElementById("cookieSetName").value.trim();
+  if (cookieName.length == 0) {
+    resultError(resultP, "cookie name is mandatory", button);
+    return;
+  }
+  let url = "/cookie/set?name=" + encodeURIComponent(cookieName);
+  // attributes
+  let attributes = [["value", "cookieSetValue"], ["domain", "cookieSetDomain"], ["path", "cookieSetPath"], ["same_site", "cookieSetSameSite"], ["max_age", "cookieSetMaxAge"]];
+  for (let [param, id] of attributes) {
+    let value = document.getElementById(id).value;
+    if (value.length != 0) {
+      url += "&" + param + "=" + encodeURIComponent(value.trim());
+    }
+  }
+  if (document.getElementById("cookieSetSecure").checked) {
+    url += "&secure=true";
+  }
+  if (document.getElementById("cookieSetHTTPOnly").checked) {
+    url += "&http_only=true";
+  }
+
+  // building request
+  let xhr = new XMLHttpRequest();
+  xhr.onreadystatechange = function() {
+    if (this.readyState == 4) {
+      if (this.status == 200) {
+        resultOk(resultP, this.responseText, button);
+      } else if (this.status != 0) {
+        resultError(resultP, this.responseText, button);
+      } else {
+        resultConnectionError(resultP, button);
+      }
+    }
+  };
+
+  // sending request
+  xhr.open("GET", url, true);
+  xhr.send();
+}
+
+// cookie list
+function cookieList(button, resultP) {
+  clearOldResult(button, resultP);
+
+  // building request
+  let xhr = new XMLHttpRequest();
+  xhr.onreadystatechange = function() {
+    if (this.readyState == 4) {
+      if (this.status == 200) {
+        if (this.responseText.length == 0) {
+          resultOk(resultP, "no cookie sent", button);
+        } else {
+          resultOk(resultP, this.responseText.replace(/(?:\r\n|\r|\n)/g, '<br>'), button);
+        }
+      } else if (this.status != 0) {
+        resultError(resultP, this.responseText, button);
+      } else {
+        resultConnectionError(resultP, button);
+      }
+    }
+  };
+
+  // sending request
+  xhr.open("GET", "/cookie/list", true);
+  xhr.send();
+}
+
+// cookie delete
+function cookieDelete(button, resultP) {
+  clearOldResult(button, resultP);
+
+  // building URL
+  let url = "/cookie/delete";
+  let queryParams = new Array();
+  let cookieName = document.getElementById("cookieDeleteName").value;
+  if (cookieName.length != 0) {
+    queryParams.push("name=" + encodeURIComponent(cookieName.trim()));
+  }
+  let cookiePath = document.getElementById("cookieDeletePath").value;
+  if (cookiePath.length != 0) {
+    queryParams.push("path=" + encodeURIComponent(cookiePath.trim()));
+  }
+  if (queryParams.length > 0) {
+    url += "?" + queryParams.join("&")
+  }
+
+  // building request
+  let xhr = new XMLHttpRequest();
+  xhr.onreadystatechange = function() {
+    if (this.readyState == 4) {
+      if (this.status == 200) {
+        if (this.responseText.length == 0) {
+          resultOk(resultP, "no cookie to delete", button);
+        } else {
+          resultOk(resultP, this.responseText.replace(/(?:\r\n|\r|\n)/g, '<br>'), button);
+        }
+      } else if (this.status != 0) {
+        resultError(resultP, this.responseText, button);
+      } else {
+        resultConnectionError(resultP, button);
+      }
+    }
+  };
+
+  // sending request
+  xhr.open("GET", url, true);
+  xhr.send();
+}
+
+// session
+function session(button, resultP) {
+  clearOldResult(button, resultP);
+
+  // building URL
+  let url = "/session";
+  let sessionName = document.getElementById("sessionName").value;
+  if (sessionName.length != 0) {
+    url += "?name=" + encodeURIComponent(sessionName.trim());
+  }
+
+  // building request
+  let xhr = new XMLHttpRequest();
+  xhr.onreadystatechange = function() {
+    if (this.readyState == 4) {
+      if (this.status == 200) {
+        resultOk(resultP, this.responseText.replace(/(?:\r\n|\r|\n)/g, '<br>'), button);
+      } else if (this.status != 0) {
+        resultError(resultP, this.responseText, button);
+      } else {
+        resultConnectionError(resultP, button);
+      }
+    }
+  };
+
+  // sending request
+  xhr.open("GET", url, true);
+  xhr.send();
+}
+
+// whoami
+function whoami(button, resultP) {
+  clearOldResult(button, resultP);
+
+  // building request
+  let xhr = new XMLHttpRequest();
+  xhr.onreadystatechange = function() {
+    if (this.readyState == 4) {
+      if (this.status == 200) {
+        resultOk(resultP, this.responseText.replace(/(?:\r\n|\r|\n)/g, '<br>'), button);
+      } else if (this.status != 0) {
+        resultError(resultP, this.responseText, button);
+      } else {
+        resultConnectionError(resultP, button);
+      }
+    }
+  };
+
+  // sending request
+  xhr.open("GET", "/whoami", true);
+  xhr.send();
+}
+
 // CPU load
 function cpuLoad(button, resultP) {
   clearOldResult(button, resultP);
@@ -493,6 +875,374 @@ function ramStatus(button, resultP) {
   xhr.send();
 }
 
+// disk fill
+function diskFill(button, resultP) {
+  clearOldResult(button, resultP);
+
+  // building URL
+  let url = "/disk/fill";
+  let queryParams = new Array();
+  // size
+  var diskFillSize = document.getElementById("diskFillSize").value;
+  if (diskFillSize.length != 0) {
+    queryParams.push("size=" + encodeURIComponent(diskFillSize.trim()));
+  }
+  // percentage
+  var diskFillPercentage = document.getElementById("diskFillPercentage").value;
+  if (diskFillPercentage.length != 0) {
+    queryParams.push("percentage=" + encodeURIComponent(diskFillPercentage.trim()));
+  }
+  // query params
+  if (queryParams.length > 0) {
+    url += "?" + queryParams.join("&")
+  }
+
+  // building request
+  let xhr = new XMLHttpRequest();
+  xhr.onreadystatechange = function() {
+    if (this.readyState == 4) {
+      if (this.status == 200) {
+        resultOk(resultP, this.responseText, button);
+      } else if (this.status != 0) {
+        resultError(resultP, this.responseText.replace(/(?:\r\n|\r|\n)/g, '<br>'), button);
+      } else {
+        resultConnectionError(resultP, button);
+      }
+    }
+  };
+
+  // sending request
+  xhr.open("GET", url, true);
+  xhr.send();
+}
+
+// disk leak
+function diskLeak(button, resultP) {
+  clearOldResult(button, resultP);
+
+  // building URL
+  let url = "/disk/leak";
+  let queryParams = new Array();
+  // size
+  var diskLeakSize = document.getElementById("diskLeakSize").value;
+  if (diskLeakSize.length != 0) {
+    queryParams.push("size=" + encodeURIComponent(diskLeakSize.trim()));
+  }
+  // frequency
+  var diskLeakFrequency = document.getElementById("diskLeakFrequency").value;
+  if (diskLeakFrequency.length != 0) {
+    queryParams.push("frequency=" + encodeURIComponent(diskLeakFrequency.trim()));
+  }
+  // query params
+  if (queryParams.length > 0) {
+    url += "?" + queryParams.join("&")
+  }
+
+  // building request
+  let xhr = new XMLHttpRequest();
+  xhr.onreadystatechange = function() {
+    if (this.readyState == 4) {
+      if (this.status == 200) {
+        resultOk(resultP, "server disk leak triggered", button);
+      } else if (this.status != 0) {
+        resultError(resultP, this.responseText.replace(/(?:\r\n|\r|\n)/g, '<br>'), button);
+      } else {
+        resultConnectionError(resultP, button);
+      }
+    }
+  };
+
+  // sending request
+  xhr.open("GET", url, true);
+  xhr.send();
+}
+
+// disk I/O
+function diskIO(button, resultP) {
+  clearOldResult(button, resultP);
+
+  // building URL
+  let url = "/disk/io";
+  let queryParams = new Array();
+  // mode
+  var diskIOMode = document.getElementById("diskIOMode").value;
+  if (diskIOMode.length != 0) {
+    queryParams.push("mode=" + encodeURIComponent(diskIOMode.trim()));
+  }
+  // block size
+  var diskIOBlockSize = document.getElementById("diskIOBlockSize").value;
+  if (diskIOBlockSize.length != 0) {
+    queryParams.push("block_size=" + encodeURIComponent(diskIOBlockSize.trim()));
+  }
+  // iops
+  var diskIOIOPS = document.getElementById("diskIOIOPS").value;
+  if (diskIOIOPS.length != 0) {
+    queryParams.push("iops=" + encodeURIComponent(diskIOIOPS.trim()));
+  }
+  // number of workers
+  var diskIONbThreads = document.getElementById("diskIONbThreads").value;
+  if (diskIONbThreads.length != 0) {
+    queryParams.push("nb_threads=" + encodeURIComponent(diskIONbThreads.trim()));
+  }
+  // timeout
+  var diskIOTimeout = document.getElementById("diskIOTimeout").value;
+  if (diskIOTimeout.length != 0) {
+    queryParams.push("timeout=" + encodeURIComponent(diskIOTimeout.trim()));
+  }
+  // fsync
+  if (document.getElementById("diskIOFsync").checked) {
+    queryParams.push("fsync=true");
+  }
+  // query params
+  if (queryParams.length > 0) {
+    url += "?" + queryParams.join("&")
+  }
+
+  // building request
+  let xhr = new XMLHttpRequest();
+  xhr.onreadystatechange = function() {
+    if (this.readyState == 4) {
+      if (this.status == 200) {
+        resultOk(resultP, "server disk I/O workers started", button);
+      } else if (this.status != 0) {
+        resultError(resultP, this.responseText.replace(/(?:\r\n|\r|\n)/g, '<br>'), button);
+      } else {
+        resultConnectionError(resultP, button);
+      }
+    }
+  };
+
+  // sending request
+  xhr.open("GET", url, true);
+  xhr.send();
+}
+
+// disk benchmark
+function diskBenchmark(button, resultP) {
+  clearOldResult(button, resultP);
+
+  // building URL
+  let url = "/disk/benchmark";
+  let queryParams = new Array();
+  // folder
+  var diskBenchmarkFolder = document.getElementById("diskBenchmarkFolder").value;
+  if (diskBenchmarkFolder.length != 0) {
+    queryParams.push("folder=" + encodeURIComponent(diskBenchmarkFolder.trim()));
+  }
+  // block size
+  var diskBenchmarkBlockSize = document.getElementById("diskBenchmarkBlockSize").value;
+  if (diskBenchmarkBlockSize.length != 0) {
+    queryParams.push("block_size=" + encodeURIComponent(diskBenchmarkBlockSize.trim()));
+  }
+  // duration
+  var diskBenchmarkDuration = document.getElementById("diskBenchmarkDuration").value;
+  if (diskBenchmarkDuration.length != 0) {
+    queryParams.push("duration=" + encodeURIComponent(diskBenchmarkDuration.trim()));
+  }
+  // direct
+  if (document.getElementById("diskBenchmarkDirect").checked) {
+    queryParams.push("direct=true");
+  }
+  // fsync
+  if (document.getElementById("diskBenchmarkFsync").checked) {
+    queryParams.push("fsync=true");
+  }
+  // query params
+  if (queryParams.length > 0) {
+    url += "?" + queryParams.join("&")
+  }
+
+  // building request
+  let xhr = new XMLHttpRequest();
+  xhr.onreadystatechange = function() {
+    if (this.readyState == 4) {
+      if (this.status == 200) {
+        resultOk(resultP, this.responseText.replace(/(?:\r\n|\r|\n)/g, '<br>'), button);
+      } else if (this.status != 0) {
+        resultError(resultP, this.responseText.replace(/(?:\r\n|\r|\n)/g, '<br>'), button);
+      } else {
+        resultConnectionError(resultP, button);
+      }
+    }
+  };
+
+  // sending request
+  xhr.open("GET", url, true);
+  xhr.send();
+}
+
+// disk reset
+function diskReset(button, resultP) {
+  clearOldResult(button, resultP);
+
+  // building URL
+  let url = "/disk/reset";
+
+  // building request
+  let xhr = new XMLHttpRequest();
+  xhr.onreadystatechange = function() {
+    if (this.readyState == 4) {
+      if (this.status == 200) {
+        resultOk(resultP, "server disk leak reset<br />"+this.responseText, button);
+      } else if (this.status != 0) {
+        resultError(resultP, this.responseText.replace(/(?:\r\n|\r|\n)/g, '<br>'), button);
+      } else {
+        resultConnectionError(resultP, button);
+      }
+    }
+  };
+
+  // sending request
+  xhr.open("GET", url, true);
+  xhr.send();
+}
+
+// disk status
+function diskStatus(button, resultP) {
+  clearOldResult(button, resultP);
+
+  // building URL
+  let url = "/disk/status";
+
+  // building request
+  let xhr = new XMLHttpRequest();
+  xhr.onreadystatechange = function() {
+    if (this.readyState == 4) {
+      if (this.status == 200) {
+        resultOk(resultP, this.responseText, button);
+      } else if (this.status != 0) {
+        resultError(resultP, this.responseText.replace(/(?:\r\n|\r|\n)/g, '<br>'), button);
+      } else {
+        resultConnectionError(resultP, button);
+      }
+    }
+  };
+
+  // sending request
+  xhr.open("GET", url, true);
+  xhr.send();
+}
+
+// leak file descriptors
+function leakFile(button, resultP) {
+  leakStart(button, resultP, "/leak/file", "File", new Array());
+}
+
+// leak sockets
+function leakSocket(button, resultP) {
+  let queryParams = new Array();
+  var leakSocketHost = document.getElementById("leakSocketHost").value;
+  if (leakSocketHost.length != 0) {
+    queryParams.push("host=" + encodeURIComponent(leakSocketHost.trim()));
+  }
+  leakStart(button, resultP, "/leak/socket", "Socket", queryParams);
+}
+
+// leak goroutines
+function leakGoroutine(button, resultP) {
+  let queryParams = new Array();
+  if (document.getElementById("leakGoroutineThread").checked) {
+    queryParams.push("thread=true");
+  }
+  leakStart(button, resultP, "/leak/goroutine", "Goroutine", queryParams);
+}
+
+// starts a leak worker, with the count, frequency and max of the given form
+function leakStart(button, resultP, url, name, queryParams) {
+  clearOldResult(button, resultP);
+
+  // building URL
+  // count
+  var leakCount = document.getElementById("leak" + name + "Count").value;
+  if (leakCount.length != 0) {
+    queryParams.push("count=" + encodeURIComponent(leakCount.trim()));
+  }
+  // frequency
+  var leakFrequency = document.getElementById("leak" + name + "Frequency").value;
+  if (leakFrequency.length != 0) {
+    queryParams.push("frequency=" + encodeURIComponent(leakFrequency.trim()));
+  }
+  // max
+  var leakMax = document.getElementById("leak" + name + "Max").value;
+  if (leakMax.length != 0) {
+    queryParams.push("max=" + encodeURIComponent(leakMax.trim()));
+  }
+  // query params
+  if (queryParams.length > 0) {
+    url += "?" + queryParams.join("&")
+  }
+
+  // building request
+  let xhr = new XMLHttpRequest();
+  xhr.onreadystatechange = function() {
+    if (this.readyState == 4) {
+      if (this.status == 200) {
+        resultOk(resultP, "server leak worker started", button);
+      } else if (this.status != 0) {
+        resultError(resultP, this.responseText.replace(/(?:\r\n|\r|\n)/g, '<br>'), button);
+      } else {
+        resultConnectionError(resultP, button);
+      }
+    }
+  };
+
+  // sending request
+  xhr.open("GET", url, true);
+  xhr.send();
+}
+
+// leak reset
+function leakReset(button, resultP) {
+  clearOldResult(button, resultP);
+
+  // building URL
+  let url = "/leak/reset";
+
+  // building request
+  let xhr = new XMLHttpRequest();
+  xhr.onreadystatechange = function() {
+    if (this.readyState == 4) {
+      if (this.status == 200) {
+        resultOk(resultP, "server leaks reset<br />"+this.responseText, button);
+      } else if (this.status != 0) {
+        resultError(resultP, this.responseText.replace(/(?:\r\n|\r|\n)/g, '<br>'), button);
+      } else {
+        resultConnectionError(resultP, button);
+      }
+    }
+  };
+
+  // sending request
+  xhr.open("GET", url, true);
+  xhr.send();
+}
+
+// leak status
+function leakStatus(button, resultP) {
+  clearOldResult(button, resultP);
+
+  // building URL
+  let url = "/leak/status";
+
+  // building request
+  let xhr = new XMLHttpRequest();
+  xhr.onreadystatechange = function() {
+    if (this.readyState == 4) {
+      if (this.status == 200) {
+        resultOk(resultP, this.responseText, button);
+      } else if (this.status != 0) {
+        resultError(resultP, this.responseText.replace(/(?:\r\n|\r|\n)/g, '<br>'), button);
+      } else {
+        resultConnectionError(resultP, button);
+      }
+    }
+  };
+
+  // sending request
+  xhr.open("GET", url, true);
+  xhr.send();
+}
+
 // started
 function startedGet(button, resultP) {
   monitoringGet(button, resultP, "/started");
@@ -746,6 +1496,16 @@ function request(button, resultP) {
   if (echoBody) {
       formData.append("echo_body", echoBody);
   }
+  // echo redirects
+  let echoRedirects = document.getElementById("requestEchoRedirects").checked;
+  if (echoRedirects) {
+      formData.append("echo_redirects", echoRedirects);
+  }
+  // max redirects
+  let maxRedirects = document.getElementById("requestMaxRedirects").value;
+  if (maxRedirects.length > 0) {
+    formData.append("max_redirects", maxRedirects);
+  }
 
   // tls
   if (requestURL.startsWith('https:\/\/') || requestURL.startsWith('wss:\/\/')) {